@@ -0,0 +1,330 @@
+// fileAdmin
+package fwrite
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AdminHeader = "X-Fwrite-Admin" //管理操作请求头, 值不为空
+)
+
+//写入器管理HTTP处理器, 挂载到内部管理端口, 挂载前缀使用http.StripPrefix去除
+//	GET  /writers                 	列出写入器配置、当前文件、尺寸和行数
+//	GET  /writers/{name}          	读取写入器
+//	GET  /writers/{name}/files    	列出封存文件及尺寸
+//	POST /writers/{name}/rotate   	执行文件旋转
+//	POST /writers/{name}/flush    	写入缓存数据
+//	POST /writers/{name}/clean    	执行文件清理, dryRun=true时只列出将被删除的文件
+//	POST /writers/{name}/retention	修改保留策略, 参数: cleaning, maxDays, maxAgeDays
+//	GET  /metrics                 	Prometheus文本格式运行统计
+//POST请求须带AdminHeader请求头, 跨站表单和简单请求无法设置自定义请求头, 否则返回403
+//安全: 处理器不做任何认证和鉴权, 任何能访问端口的人都可以旋转、清理和修改保留策略,
+//	只能挂载到仅内部可达的端口, 或由调用者在外层包装认证
+type Admin struct {
+	writers map[string]*FileWrite
+	mu      sync.RWMutex
+}
+
+//创建写入器管理处理器
+//writers	输入登记的写入器
+func NewAdmin(writers ...*FileWrite) *Admin {
+	a := &Admin{writers: make(map[string]*FileWrite)}
+	a.Register(writers...)
+	return a
+}
+
+//登记写入器, 同名写入器被替换
+func (a *Admin) Register(writers ...*FileWrite) {
+	a.mu.Lock()
+	for _, w := range writers {
+		a.writers[w._Name_] = w
+	}
+	a.mu.Unlock()
+}
+
+//解除登记写入器
+func (a *Admin) Unregister(name string) {
+	a.mu.Lock()
+	delete(a.writers, name)
+	a.mu.Unlock()
+}
+
+//获取登记的写入器, 按名称排序
+func (a *Admin) list() []*FileWrite {
+	a.mu.RLock()
+	writers := make([]*FileWrite, 0, len(a.writers))
+	for _, w := range a.writers {
+		writers = append(writers, w)
+	}
+	a.mu.RUnlock()
+
+	sort.Slice(writers, func(i, j int) bool { return writers[i]._Name_ < writers[j]._Name_ })
+	return writers
+}
+
+//写入器配置, FileConfig中可序列化的部分
+type ConfigInfo struct {
+	FilePrefix   string `json:"filePrefix"`   //文件名前缀
+	WriteSuffix  string `json:"writeSuffix"`  //正在写文件后缀
+	RenameSuffix string `json:"renameSuffix"` //重命名文件后缀
+	CleanSuffix  string `json:"cleanSuffix"`  //清理文件后缀
+	FileSync     bool   `json:"fileSync"`     //是否同步写文件
+	FileLock     bool   `json:"fileLock"`     //是否文件锁定
+	FileZip      bool   `json:"fileZip"`      //是否压缩文件
+	Rotate       bool   `json:"rotate"`       //是否自动分割
+	Dayend       bool   `json:"dayend"`       //文件日终切换
+	ZeroSize     bool   `json:"zeroSize"`     //新文件零尺寸
+	MaxLines     int64  `json:"maxLines"`     //最大行数
+	MaxSize      int64  `json:"maxSize"`      //最大尺寸
+	StateFile    bool   `json:"stateFile"`    //是否保存状态文件
+	IndexEvery   int64  `json:"indexEvery"`   //索引间隔行数
+	FrameMode    bool   `json:"frameMode"`    //是否二进制帧模式
+	SeqMode      bool   `json:"seqMode"`      //是否分配全局序号
+	ChainEvery   int64  `json:"chainEvery"`   //哈希链间隔记录数
+	Manifest     bool   `json:"manifest"`     //是否写入校验清单
+	Encrypted    bool   `json:"encrypted"`    //是否加密
+	SharedMode   bool   `json:"sharedMode"`   //是否多进程共享写入
+	LockStrict   bool   `json:"lockStrict"`   //是否独占前缀
+	Cleaning     bool   `json:"cleaning"`     //清理历史
+	MaxDays      int    `json:"maxDays"`      //最大天数
+	MaxAgeDays   int    `json:"maxAgeDays"`   //强制清理天数
+}
+
+//写入器概要
+type WriterInfo struct {
+	Name     string     `json:"name"`     //写入器名称
+	FileName string     `json:"fileName"` //当前文件名
+	Size     int64      `json:"size"`     //当前文件尺寸
+	Lines    int64      `json:"lines"`    //当前文件行数
+	Config   ConfigInfo `json:"config"`   //当前配置
+}
+
+//封存文件
+type SealedFile struct {
+	Name    string    `json:"name"`    //文件名
+	Size    int64     `json:"size"`    //文件尺寸
+	ModTime time.Time `json:"modTime"` //修改时间
+}
+
+//读取写入器概要
+func (w *FileWrite) Info() WriterInfo {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	c := w.cfg
+	return WriterInfo{
+		Name:     w._Name_,
+		FileName: c.FileName,
+		Size:     c.CurSize,
+		Lines:    c.CurLines,
+		Config: ConfigInfo{
+			FilePrefix:   c.FilePrefix,
+			WriteSuffix:  c.WriteSuffix,
+			RenameSuffix: c.RenameSuffix,
+			CleanSuffix:  c.CleanSuffix,
+			FileSync:     c.FileSync,
+			FileLock:     c.FileLock,
+			FileZip:      c.FileZip,
+			Rotate:       c.Rotate,
+			Dayend:       c.Dayend,
+			ZeroSize:     c.ZeroSize,
+			MaxLines:     c.MaxLines,
+			MaxSize:      c.MaxSize,
+			StateFile:    c.StateFile,
+			IndexEvery:   c.IndexEvery,
+			FrameMode:    c.FrameMode,
+			SeqMode:      c.SeqMode,
+			ChainEvery:   c.ChainEvery,
+			Manifest:     c.Manifest,
+			Encrypted:    c.KeyProvider != nil,
+			SharedMode:   c.SharedMode,
+			LockStrict:   c.LockStrict,
+			Cleaning:     c.Cleaning,
+			MaxDays:      c.MaxDays,
+			MaxAgeDays:   c.MaxAgeDays,
+		},
+	}
+}
+
+//列出封存文件, 按日期和序号排列, 不含活动文件
+func (w *FileWrite) SealedFiles() ([]SealedFile, error) {
+	r := w.NewReader()
+	names, err := r.Files()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]SealedFile, 0, len(names))
+	for _, name := range names {
+		if name == r.activeName() {
+			continue
+		}
+		if info, err := os.Stat(name); err == nil {
+			files = append(files, SealedFile{Name: name, Size: info.Size(), ModTime: info.ModTime()})
+		}
+	}
+	return files, nil
+}
+
+//输出JSON应答
+//rw    	输入应答
+//status	输入状态码
+//v     	输入应答内容
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(v)
+}
+
+//输出错误应答
+//rw    	输入应答
+//status	输入状态码
+//err   	输入错误
+func writeError(rw http.ResponseWriter, status int, err error) {
+	writeJSON(rw, status, map[string]string{"error": err.Error()})
+}
+
+func (a *Admin) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	if path == "metrics" {
+		MetricsHandler(a.list()...).ServeHTTP(rw, r)
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	if parts[0] != "writers" || len(parts) > 3 {
+		http.NotFound(rw, r)
+		return
+	}
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			writeError(rw, http.StatusMethodNotAllowed, errorf("method %s not allowed", r.Method))
+			return
+		}
+		writers := a.list()
+		infos := make([]WriterInfo, len(writers))
+		for i, w := range writers {
+			infos[i] = w.Info()
+		}
+		writeJSON(rw, http.StatusOK, infos)
+		return
+	}
+
+	a.mu.RLock()
+	w := a.writers[parts[1]]
+	a.mu.RUnlock()
+	if w == nil {
+		writeError(rw, http.StatusNotFound, errorf("writer \"%s\" not found", parts[1]))
+		return
+	}
+
+	op := ""
+	if len(parts) == 3 {
+		op = parts[2]
+	}
+	switch op {
+	case "", "files":
+		if r.Method != http.MethodGet {
+			writeError(rw, http.StatusMethodNotAllowed, errorf("method %s not allowed", r.Method))
+			return
+		}
+	case "rotate", "flush", "clean", "retention":
+		if r.Method != http.MethodPost {
+			writeError(rw, http.StatusMethodNotAllowed, errorf("method %s not allowed", r.Method))
+			return
+		}
+		//自定义请求头要求浏览器跨站请求先预检, 防止内部网页跨站提交表单
+		if r.Header.Get(AdminHeader) == "" {
+			writeError(rw, http.StatusForbidden, errorf("header %s required", AdminHeader))
+			return
+		}
+	default:
+		http.NotFound(rw, r)
+		return
+	}
+	a.serveWriter(rw, r, w, op)
+}
+
+//执行写入器操作
+//rw	输入应答
+//r 	输入请求
+//w 	输入写入器
+//op	输入操作, 为空时读取写入器
+func (a *Admin) serveWriter(rw http.ResponseWriter, r *http.Request, w *FileWrite, op string) {
+	switch op {
+	case "":
+		writeJSON(rw, http.StatusOK, w.Info())
+
+	case "files":
+		files, err := w.SealedFiles()
+		if err != nil {
+			writeError(rw, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(rw, http.StatusOK, files)
+
+	case "rotate":
+		if err := w.Rotate(); err != nil {
+			writeError(rw, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(rw, http.StatusOK, w.Info())
+
+	case "flush":
+		w.Flush()
+		writeJSON(rw, http.StatusOK, w.Info())
+
+	case "clean":
+		dryRun, _ := strconv.ParseBool(r.FormValue("dryRun"))
+		var err error
+		var files []string
+		if dryRun {
+			err, files = w.FileCleanDryRun()
+		} else {
+			err, files = w.FileClean()
+		}
+		result := struct {
+			DryRun bool     `json:"dryRun"`
+			Files  []string `json:"files"`
+			Error  string   `json:"error,omitempty"`
+		}{DryRun: dryRun, Files: files}
+		status := http.StatusOK
+		if err != nil {
+			result.Error, status = err.Error(), http.StatusInternalServerError
+		}
+		writeJSON(rw, status, result)
+
+	case "retention":
+		var cleaning *bool
+		var maxDays, maxAgeDays *int
+		var err error
+		if v := r.FormValue("cleaning"); v != "" && err == nil {
+			cleaning = new(bool)
+			*cleaning, err = strconv.ParseBool(v)
+		}
+		if v := r.FormValue("maxDays"); v != "" && err == nil {
+			maxDays = new(int)
+			*maxDays, err = strconv.Atoi(v)
+		}
+		if v := r.FormValue("maxAgeDays"); v != "" && err == nil {
+			maxAgeDays = new(int)
+			*maxAgeDays, err = strconv.Atoi(v)
+		}
+		if err == nil {
+			err = w.UpdateRetention(cleaning, maxDays, maxAgeDays)
+		}
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(rw, http.StatusOK, w.Info())
+	}
+}
@@ -0,0 +1,410 @@
+// fileChain
+package fwrite
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	chainPrefix = "#CHAIN " //哈希链记录前缀
+	chainHexLen = 2 * sha256.Size
+	chainLen    = len(chainPrefix) + 2*chainHexLen + 1 //哈希链记录长度, 不含换行符
+)
+
+var (
+	ErrChainBroken = errors.New("chain broken")        //链记录前值与上一链记录不符
+	ErrChainHash   = errors.New("chain hash mismatch") //链记录哈希与内容不符
+	ErrChainOpen   = errors.New("chain not closed")    //封存文件未以链记录结束
+)
+
+//哈希链校验错误
+type ChainError struct {
+	FileName string //文件名
+	Offset   int64  //链记录偏移
+	Err      error  //错误原因
+}
+
+func (e *ChainError) Error() string {
+	return sprintf("chain \"%s\" offset %d: %v", e.FileName, e.Offset, e.Err)
+}
+
+func (e *ChainError) Unwrap() error {
+	return e.Err
+}
+
+//哈希链校验报告
+type ChainReport struct {
+	Files     int    //校验文件数
+	Chains    int64  //校验链记录数
+	Unchained int64  //未被链记录覆盖的字节数, 如文件结束填充和活动文件末尾
+	Last      string //最后链哈希
+}
+
+//获取哈希链间隔记录数和HMAC密钥
+func (c *FileConfig) GetChain() (int64, []byte) {
+	return c.ChainEvery, c.ChainKey
+}
+
+//哈希链记录写入尺寸
+func (c *FileConfig) chainSize() int64 {
+	if c.FrameMode {
+		return int64(frameHead + chainLen)
+	}
+	return int64(chainLen + 1)
+}
+
+//计算链哈希: H(前值 + 段内容摘要), 有密钥时为HMAC-SHA256
+//key   	输入HMAC密钥
+//prev  	输入前值
+//segSum	输入段内容SHA-256摘要
+func chainSum(key, prev, segSum []byte) []byte {
+	var h hash.Hash
+	if len(key) > 0 {
+		h = hmac.New(sha256.New, key)
+	} else {
+		h = sha256.New()
+	}
+	h.Write(prev)
+	h.Write(segSum)
+	return h.Sum(nil)
+}
+
+//生成哈希链记录, 不含换行符或帧头
+func chainRecord(prev, sum []byte) []byte {
+	b := make([]byte, 0, chainLen+1)
+	b = append(b, chainPrefix...)
+	b = hex.AppendEncode(b, prev)
+	b = append(b, ' ')
+	return hex.AppendEncode(b, sum)
+}
+
+//解析哈希链记录
+//data	输入记录数据, 不含换行符或帧头
+func parseChain(data []byte) (prev, sum []byte, ok bool) {
+	if len(data) != chainLen || !bytes.HasPrefix(data, []byte(chainPrefix)) ||
+		data[len(chainPrefix)+chainHexLen] != ' ' {
+		return nil, nil, false
+	}
+	data = data[len(chainPrefix):]
+	prev, err := hex.DecodeString(string(data[:chainHexLen]))
+	if err != nil {
+		return nil, nil, false
+	}
+	sum, err = hex.DecodeString(string(data[chainHexLen+1:]))
+	if err != nil {
+		return nil, nil, false
+	}
+	return prev, sum, true
+}
+
+//是否哈希链记录
+func isChainRecord(data []byte) bool {
+	_, _, ok := parseChain(data)
+	return ok
+}
+
+//遍历文件内容, 区分哈希链记录与普通内容
+//rd      	输入文件内容
+//fileName	输入文件名, 用于错误信息
+//frame   	输入是否二进制帧模式
+//fn      	输入处理函数, unit为写入的原始字节, 链记录时prev和sum非空
+func walkChain(rd io.Reader, fileName string, frame bool,
+	fn func(unit, prev, sum []byte, offset int64) error) error {
+	br := bufio.NewReaderSize(rd, 32768) // 32k
+	var offset int64
+	var partial []byte
+	for {
+		var unit, data []byte
+		if frame {
+			payload, size, err := readFrame(br, &partial)
+			if err == io.EOF || err == io.ErrUnexpectedEOF { //活动文件末尾不完整帧
+				return nil
+			}
+			if err != nil {
+				return &FrameError{FileName: fileName, Offset: offset, Err: err}
+			}
+			unit, data = appendFrame(make([]byte, 0, size), payload), payload
+		} else {
+			line, err := br.ReadBytes('\n')
+			if len(line) == 0 {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+			unit, data = line, line
+			if err == nil {
+				data = line[:len(line)-1]
+			}
+		}
+		prev, sum, _ := parseChain(data)
+		if err := fn(unit, prev, sum, offset); err != nil {
+			return err
+		}
+		offset += int64(len(unit))
+	}
+}
+
+//读取文件最后链哈希
+//fileName	输入文件名, 可为压缩文件
+//frame   	输入是否二进制帧模式
+//keys    	输入解密密钥提供者
+func lastChainSum(fileName string, frame bool, keys KeyProvider) []byte {
+	rc, err := openRecordFile(fileName, keys)
+	if err != nil {
+		return nil
+	}
+	defer rc.Close()
+
+	var last []byte
+	walkChain(rc, fileName, frame, func(unit, prev, sum []byte, offset int64) error {
+		if sum != nil {
+			last = sum
+		}
+		return nil
+	})
+	return last
+}
+
+//当前文件哈希链
+type fileChain struct {
+	on   bool      //是否启用
+	key  []byte    //HMAC密钥
+	prev []byte    //前值
+	seg  hash.Hash //段内容摘要
+	size int64     //段内容尺寸
+}
+
+func (c *fileChain) start(prev []byte) {
+	c.prev, c.size = prev, 0
+	if c.seg == nil {
+		c.seg = sha256.New()
+	}
+	c.seg.Reset()
+}
+
+func (c *fileChain) add(b []byte) {
+	if c.on {
+		c.seg.Write(b)
+		c.size += int64(len(b))
+	}
+}
+
+//前值十六进制, 未启用为空
+func (c *fileChain) prevHex() string {
+	if !c.on {
+		return ""
+	}
+	return hex.EncodeToString(c.prev)
+}
+
+//新文件恢复哈希链(已持有锁), 前值取自当前文件或上一封存文件的最后链记录,
+//当前文件最后链记录之后的内容计入新的段
+//fileName	输入文件名
+//fileSize	输入文件尺寸
+func (mw *MutexWrite) resumeChain(fileName string, fileSize int64) {
+	every, key := mw.cfger.GetChain()
+	if mw.chain.on, mw.chain.key = every > 0, key; !mw.chain.on {
+		return
+	}
+	frame := mw.cfger.IsFrameMode()
+
+	prev := mw.chain.prev
+	if prev == nil { //首次打开时从上一封存文件恢复
+		if entries, _ := loadNames(fileName); len(entries) > 0 {
+			last := filepath.Join(filepath.Dir(fileName), entries[len(entries)-1].To)
+			if name, err := existFileName(last); err == nil {
+				prev = lastChainSum(name, frame, mw.cfger.GetKeyProvider())
+			}
+		}
+		if prev == nil {
+			prev = make([]byte, sha256.Size)
+		}
+	}
+	mw.chain.start(prev)
+	if fileSize == 0 {
+		return
+	}
+
+	fd, err := openPlain(fileName, mw.cfger.GetKeyProvider())
+	if err != nil {
+		mw.log(LevelError, "open chain", fileName, err)
+		return
+	}
+	defer fd.Close()
+	err = walkChain(io.LimitReader(fd, fileSize), fileName, frame,
+		func(unit, prev, sum []byte, offset int64) error {
+			if sum != nil {
+				mw.chain.start(sum)
+			} else {
+				mw.chain.add(unit)
+			}
+			return nil
+		})
+	if err != nil {
+		mw.log(LevelError, "resume chain", fileName, err)
+	}
+}
+
+//写入哈希链记录(已持有锁)
+func (mw *MutexWrite) writeChain() error {
+	if !mw.chain.on || mw.closed || mw.file == nil || mw.file == os.Stdout {
+		return nil
+	}
+	sum := chainSum(mw.chain.key, mw.chain.prev, mw.chain.seg.Sum(nil))
+	line := chainRecord(mw.chain.prev, sum)
+	if mw.cfger.IsFrameMode() {
+		line = appendFrame(nil, line)
+	} else {
+		line = append(line, '\n')
+	}
+	n, err := mw.writeFile(line)
+	mw.index.add(line[:n])
+	mw.chain.start(sum)
+	if err != nil {
+		mw.log(LevelError, "write chain", mw.file.Name(), err)
+	}
+	return err
+}
+
+//互斥写哈希链记录（Go程安全）, 链接上一链记录之后写入的全部内容
+func (mw *MutexWrite) WriteChain() error {
+	if mw == nil {
+		return ErrFileNil
+	}
+
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
+	if mw.closed {
+		return ErrFileClosed
+	}
+	return mw.writeChain()
+}
+
+//记录写入后写入哈希链记录(已持有锁), 记录写入失败时不写链记录,
+//撤销链记录计数, 在下次写入记录后补写
+//chain	输入是否需要写入链记录
+//err  	输入记录写入错误
+func (w *FileWrite) writeChainLocked(chain bool, err error) {
+	if !chain {
+		return
+	}
+	if err == nil {
+		w.muwt.WriteChain()
+		return
+	}
+	w.cfg.ChainRecords = w.cfg.ChainEvery - 1
+	w.cfg.CurLines--
+	w.cfg.CurSize -= w.cfg.chainSize()
+	if w.cfg.SeqMode {
+		w.cfg.CurSeq--
+	}
+}
+
+//记录哈希链计数, 到达间隔时计入链记录的行数和尺寸(已持有锁)
+//records	输入记录数
+//chain  	输出是否需要写入链记录
+func (w *FileWrite) chainLocked(records int64) (chain bool) {
+	if w.cfg.ChainEvery <= 0 || w.muwt.IsStdout() {
+		return false
+	}
+	if w.cfg.ChainRecords += records; w.cfg.ChainRecords < w.cfg.ChainEvery {
+		return false
+	}
+	w.cfg.ChainRecords = 0
+	w.cfg.CurLines++
+	w.cfg.CurSize += w.cfg.chainSize()
+	if w.cfg.SeqMode {
+		w.cfg.CurSeq++
+	}
+	return true
+}
+
+//设置哈希链, 每chainEvery条记录写入一条链记录, 文件封存时链接剩余记录,
+//链记录含前值, 跨文件连续
+//chainEvery	输入间隔记录数, 0为不启用
+//chainKey  	输入HMAC密钥, 为空时使用SHA-256
+func (w *FileWrite) SetChain(chainEvery int64, chainKey []byte) {
+	w.mu.Lock()
+	w.cfg.ChainEvery = chainEvery
+	w.cfg.ChainKey = chainKey
+	w.mu.Unlock()
+}
+
+//设置是否跳过哈希链记录
+func (r *Reader) SetChainMode(chain bool) {
+	r.chain = chain
+}
+
+//按写入顺序校验重命名文件、压缩文件和活动文件的哈希链, 返回第一处断链,
+//封存文件须以链记录结束, 其后只允许静态文件结束填充
+//key	输入HMAC密钥, 为空时使用SHA-256
+func (r *Reader) VerifyChain(key []byte) (report ChainReport, err error) {
+	files, err := r.Files()
+	if err != nil {
+		return report, err
+	}
+
+	var last []byte
+	seg := sha256.New()
+	for _, fileName := range files {
+		rc, err := openRecordFile(fileName, r.keys)
+		if os.IsNotExist(err) { //文件已被清理
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		report.Files++
+
+		seg.Reset()
+		size, units, tailOff := int64(0), 0, int64(0)
+		var tail bytes.Buffer
+		err = walkChain(rc, fileName, r.frame, func(unit, prev, sum []byte, offset int64) error {
+			if sum == nil {
+				seg.Write(unit)
+				size += int64(len(unit))
+				if units == 0 {
+					tailOff = offset
+				}
+				if units++; tail.Len() <= len(r.eof) {
+					tail.Write(unit)
+				}
+				return nil
+			}
+			if last != nil && !bytes.Equal(prev, last) {
+				return &ChainError{FileName: fileName, Offset: offset, Err: ErrChainBroken}
+			}
+			if !hmac.Equal(chainSum(key, prev, seg.Sum(nil)), sum) {
+				return &ChainError{FileName: fileName, Offset: offset, Err: ErrChainHash}
+			}
+			last, size, units = sum, 0, 0
+			seg.Reset()
+			tail.Reset()
+			report.Chains++
+			return nil
+		})
+		rc.Close()
+		if err != nil {
+			return report, err
+		}
+		//封存文件最后链记录之后只能是静态文件结束填充, 生成的文件结束记录由封存链记录覆盖
+		if fileName != r.activeName() && units > 0 &&
+			!(len(r.eof) > 0 && bytes.Equal(tail.Bytes(), r.eof)) {
+			return report, &ChainError{FileName: fileName, Offset: tailOff, Err: ErrChainOpen}
+		}
+		report.Unchained += size
+	}
+	report.Last = hex.EncodeToString(last)
+	return report, nil
+}
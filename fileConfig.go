@@ -37,6 +37,30 @@ type FileConfig struct {
 	FileLock     bool   //是否文件锁定
 	FileZip      bool   //是否压缩文件
 
+	// Open file registry
+	Registry *FileRegistry //打开文件登记表,为空时使用进程默认登记表
+
+	// Diagnostics
+	Logger Logger //诊断日志器,为空时使用进程默认日志器
+
+	// Error reporting
+	OnError ErrorFunc  //错误回调函数,为空时不回调
+	errs    ErrorStats //错误计数
+
+	// Lifecycle events
+	Hooks  Hooks        //生命周期事件回调
+	Events chan<- Event //生命周期事件通道,为空时不发送
+
+	// Metrics
+	metrics fileMetrics //运行计数
+
+	// File header and trailer
+	FileHeader  []byte      //文件开始填充
+	HeaderFunc  HeaderFunc  //文件开始生成函数
+	HeaderLines bool        //文件头是否计入行数
+	HeadLines   int64       //当前文件头行数
+	TrailerFunc TrailerFunc //文件结束生成函数
+
 	// Rotate at size
 	Rotate             bool  //是否自动分割
 	Dayend             bool  //文件日终切换
@@ -47,12 +71,51 @@ type FileConfig struct {
 	CurLines           int64 //当前行数
 	MaxSize            int64 //最大尺寸,最小为1M
 	CurSize            int64 //当前尺寸
+	CurOffset          int64 //最后记录偏移
+	StateFile          bool  //是否保存状态文件
+	IndexEvery         int64 //索引间隔行数,0为不建索引
+
+	// Line accounting
+	LineCount   bool //是否按换行符计算行数, 开启时补齐结尾换行符
+	LineAppend  bool //是否补齐结尾换行符
+	LineNewline int  //内嵌换行符处理方式
+	FrameMode   bool //是否二进制帧模式,行号为帧序号
+
+	// Tail repair
+	TailRepair int    //重新打开文件时尾部修复方式
+	TornMarker []byte //封存不完整记录的标记行
+
+	// Global sequence
+	SeqMode bool   //是否分配全局序号
+	CurSeq  uint64 //最后分配的全局序号
+	FileSeq uint64 //当前文件首个全局序号
+	SeqLine int64  //当前文件首个全局序号所在行号
+
+	// Hash chain
+	ChainEvery   int64  //哈希链间隔记录数,0为不启用
+	ChainKey     []byte //哈希链HMAC密钥,为空时使用SHA-256
+	ChainRecords int64  //当前未链接记录数
+
+	// Seal manifest
+	Manifest bool //是否封存时写入校验清单
+
+	// Encryption
+	KeyProvider KeyProvider //加密密钥提供者,为空时不加密
+
+	// Shared writing
+	SharedMode bool //是否多进程共享写入活动文件
+
+	// Exclusive ownership
+	LockStrict  bool          //是否独占前缀,锁定失败时初始化失败
+	LockWait    time.Duration //独占锁等待时间,0为不等待
+	LockRefresh time.Duration //锁文件持有者刷新间隔,0为不刷新
 
 	// Rotate daily
 	Cleaning          bool //清理历史
 	CleanRename       bool //清理文件时是否重命名
 	CleanRenameSuffix bool //清理文件时是否只对后缀重命名
 	MaxDays           int  //最大天数,最小为3天
+	MaxAgeDays        int  //强制清理天数,不等待消费者,0为不强制
 	CurDay            int  //当期天
 
 }
@@ -68,6 +131,12 @@ func (c *FileConfig) InitAsDefault(name string) {
 	c.FileSync = false           //默认为false
 	c.FileLock = false           //默认为false
 	c.FileName = ""              //默认为空
+	c.Registry = nil             //默认为进程默认登记表
+	c.Logger = nil               //默认为进程默认日志器
+	c.OnError = nil              //默认为不回调
+	c.Hooks = Hooks{}            //默认为不回调
+	c.Events = nil               //默认为不发送
+	c.HeaderLines = false        //默认为false
 	c.Rotate = true              //默认为true
 	c.Dayend = true              //默认为true
 	c.ZeroSize = false           //默认为false
@@ -77,10 +146,27 @@ func (c *FileConfig) InitAsDefault(name string) {
 	c.CurLines = 0               //初始为0
 	c.MaxSize = 1 << 28          //默认为256 MB
 	c.CurSize = 0                //初始为0
+	c.CurOffset = 0              //初始为0
+	c.StateFile = false          //默认为false
+	c.IndexEvery = 0             //默认为0
+	c.LineCount = false          //默认为false
+	c.LineAppend = false         //默认为false
+	c.LineNewline = NewlineKeep  //默认为保留
+	c.FrameMode = false          //默认为false
+	c.TailRepair = RepairNone    //默认为不修复
+	c.SeqMode = false            //默认为false
+	c.ChainEvery = 0             //默认为0
+	c.Manifest = false           //默认为false
+	c.KeyProvider = nil          //默认为不加密
+	c.SharedMode = false         //默认为false
+	c.LockStrict = false         //默认为false
+	c.LockWait = 0               //默认为不等待
+	c.LockRefresh = time.Minute  //默认为1分钟
 	c.Cleaning = true            //默认为true
 	c.CleanRename = false        //默认为false
 	c.CleanRenameSuffix = false  //默认为false
 	c.MaxDays = 7                //默认为7天
+	c.MaxAgeDays = 0             //默认为0
 	c.CurDay = time.Now().Day()  //初始为当前日期
 }
 
@@ -94,9 +180,25 @@ func (c *FileConfig) IsFileSync() bool {
 	return c.FileSync
 }
 
-//是否启用文件锁
+//是否启用文件锁, 独占模式由写入器持有锁
 func (c *FileConfig) IsFileLock() bool {
-	return c.FileLock
+	return c.FileLock && !c.LockStrict
+}
+
+//获取打开文件登记表
+func (c *FileConfig) GetRegistry() *FileRegistry {
+	if c.Registry != nil {
+		return c.Registry
+	}
+	return defaultRegistry
+}
+
+//获取诊断日志器
+func (c *FileConfig) GetLogger() Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return DefaultLogger()
 }
 
 //新文件零尺寸
@@ -121,7 +223,11 @@ func (c *FileConfig) GetFileEof() []byte {
 //获取文件行数
 //fileName	是输入文件名
 func (c *FileConfig) GetFileLines(fileName string) (int64, error) {
-	fd, err := os.Open(fileName)
+	if c.FrameMode {
+		return getFileFrames(fileName, c.KeyProvider)
+	}
+
+	fd, err := openPlain(fileName, c.KeyProvider)
 	if err != nil {
 		return 0, err
 	}
@@ -179,7 +285,7 @@ func (c *FileConfig) getFileRename(fileName string, modifyTime time.Time) (
 //fileRename	是输出重命名文件名
 //err       	是输出错误信息
 func (c *FileConfig) GetFileRename(fileName string) (fileRename string, err error) {
-	fileInfo, exist, _, err := FileInfo(fileName)
+	fileInfo, exist, _, err := c.GetRegistry().FileInfo(fileName)
 	if !exist { //文件不存在
 		return "", errorf("get file rename error: %v ", err)
 	}
@@ -201,20 +307,23 @@ func (c *FileConfig) GetNewFileName() (fileName string, err error) {
 	fileName = c.FilePrefix + c.WriteSuffix
 
 	if c.RotateRename {
-		if info, exist, locked, _ := FileInfo(fileName); exist && !locked { //文件存在
+		if info, exist, locked, _ := c.GetRegistry().FileInfo(fileName); exist && !locked { //文件存在
 			//尺寸大于0，并且人日期不等于当前，进行文件切换
 			if info.Size() > 0 && info.ModTime().Day() != time.Now().Day() {
 				newName, e := c.getFileRename(fileName, info.ModTime())
 				if e == nil {
 					if e = os.Rename(fileName, newName); e != nil {
-						printf("<ERROR>[%s] %s os.Rename [%s] error: %v\n\n",
-							logTime(), c.Name, fileName, e)
-					} else if c.IsFileZip() {
-						go zipLogFile(newName)
+						c.fail("rename", fileName, &RenameError{From: fileName, To: newName, Err: e})
+					} else {
+						sealRename(fileName, newName)
+						c.fireEvent(Event{Type: EventSeal, FileName: fileName,
+							NewName: newName, Size: info.Size()})
+						if c.Manifest || c.IsFileZip() {
+							go sealReport(c, c.Name, newName)
+						}
 					}
 				} else {
-					printf("<ERROR>[%s] %s get \"%s\" rename error: %v\n\n",
-						logTime(), c.Name, fileName, e)
+					c.fail("get rename", fileName, &RenameError{From: fileName, Err: e})
 				}
 			}
 		}
@@ -223,10 +332,17 @@ func (c *FileConfig) GetNewFileName() (fileName string, err error) {
 	return fileName, nil
 }
 
+//获取活动文件名, 旋转后后续记录写入该文件
+func (c *FileConfig) GetActiveName() string {
+	return c.FilePrefix + c.WriteSuffix
+}
+
 func (c *FileConfig) setCurFileName(fileName string, fileSize int64) {
 	c.FileName = fileName
 	c.CurSize = fileSize
 	c.CurLines = 0
+	c.CurOffset = 0
+	c.HeadLines = 0
 	c.CurDay = time.Now().Day()
 }
 
@@ -240,5 +356,5 @@ func (c *FileConfig) MutexWriter(fw *FileWrite, in []byte) (int, error) {
 //lineNo    是输出文件行号
 func (c *FileConfig) RotateCheck(fw *FileWrite, size int) (
 	fileName string, lineNo int64) {
-	return fw.rotateCheck(size)
+	return fw.rotateCheck(size, 1)
 }
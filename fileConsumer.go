@@ -0,0 +1,253 @@
+// fileConsumer
+package fwrite
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	flock "github.com/yireyun/go-flock"
+)
+
+const (
+	OffsetsSuffix = ".offsets" //消费位置文件后缀
+)
+
+var (
+	ErrConsumerMiss = fmt.Errorf("consumer is not registered")
+)
+
+//消费者位置
+type ConsumerOffset struct {
+	Name      string    `json:"name"`      //消费者名称
+	Position  Position  `json:"position"`  //最后处理的记录位置
+	Committed bool      `json:"committed"` //是否已提交位置
+	Updated   time.Time `json:"updated"`   //更新时间
+}
+
+//消费者滞后信息
+type ConsumerLag struct {
+	Name     string    //消费者名称
+	FileName string    //当前所在文件
+	LineNo   int64     //当前所在行号
+	Files    int       //其后未处理文件数
+	Bytes    int64     //未处理字节数, 压缩文件按压缩尺寸计算
+	Updated  time.Time //更新时间
+}
+
+//消费位置存储, 保存于日志目录的<活动文件>.offsets,
+//文件清理时等待所有消费者处理完成
+type Consumers struct {
+	reader   *Reader    //文件读取器, 用于排序和定位文件
+	fileName string     //消费位置文件名
+	mu       sync.Mutex //进程内互斥
+}
+
+//创建消费位置存储
+//cfg	输入记录器文件配置
+func NewConsumers(cfg *FileConfig) *Consumers {
+	r := NewReader(cfg)
+	return &Consumers{reader: r, fileName: r.activeName() + OffsetsSuffix}
+}
+
+//创建记录器的消费位置存储
+func (w *FileWrite) Consumers() *Consumers {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return NewConsumers(w.cfg)
+}
+
+//读取消费位置, 文件不存在时返回空
+func (c *Consumers) load() (map[string]*ConsumerOffset, error) {
+	offsets := make(map[string]*ConsumerOffset)
+	data, err := os.ReadFile(c.fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return offsets, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &offsets); err != nil {
+		return nil, err
+	}
+	return offsets, nil
+}
+
+//保存消费位置, 同步写临时文件后重命名
+func (c *Consumers) save(offsets map[string]*ConsumerOffset) error {
+	data, err := json.Marshal(offsets)
+	if err != nil {
+		return err
+	}
+	return replaceFile(c.fileName, data)
+}
+
+//修改消费位置, 持有消费位置锁文件期间重新读取文件以合并其他进程的提交
+func (c *Consumers) update(fn func(offsets map[string]*ConsumerOffset) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	lockName := c.fileName + LockSuffix
+	fl := flock.NewFlock(lockName)
+	if err := fl.Lock(); err != nil {
+		return newLockError(lockName, err)
+	}
+	defer fl.Unlock()
+
+	offsets, err := c.load()
+	if err != nil {
+		return err
+	}
+	if err = fn(offsets); err != nil {
+		return err
+	}
+	return c.save(offsets)
+}
+
+//注册消费者, 未提交位置前阻止清理所有文件
+//name	输入消费者名称
+func (c *Consumers) Register(name string) error {
+	return c.update(func(offsets map[string]*ConsumerOffset) error {
+		if _, ok := offsets[name]; !ok {
+			offsets[name] = &ConsumerOffset{Name: name, Updated: time.Now()}
+		}
+		return nil
+	})
+}
+
+//注销消费者
+//name	输入消费者名称
+func (c *Consumers) Unregister(name string) error {
+	return c.update(func(offsets map[string]*ConsumerOffset) error {
+		delete(offsets, name)
+		return nil
+	})
+}
+
+//提交消费位置
+//name	输入消费者名称
+//pos 	输入最后处理的记录位置, 如Reader读取的记录位置
+func (c *Consumers) Commit(name string, pos Position) error {
+	return c.update(func(offsets map[string]*ConsumerOffset) error {
+		offset, ok := offsets[name]
+		if !ok {
+			return ErrConsumerMiss
+		}
+		offset.Position, offset.Committed, offset.Updated = pos, true, time.Now()
+		return nil
+	})
+}
+
+//读取所有消费者位置
+func (c *Consumers) Offsets() ([]*ConsumerOffset, error) {
+	c.mu.Lock()
+	offsets, err := c.load()
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*ConsumerOffset, 0, len(offsets))
+	for _, offset := range offsets {
+		list = append(list, offset)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+//文件排序键, 活动文件排在最后
+//fileName	输入文件名
+func (r *Reader) orderKey(fileName string) (string, bool) {
+	base := filepath.Base(fileName)
+	if base == filepath.Base(r.activeName()) {
+		return "\xff", true
+	}
+	m := r.renamePattern().FindStringSubmatch(base)
+	if m == nil {
+		return "", false
+	}
+	num, _ := strconv.Atoi(m[2])
+	return sprintf("%s.%08d", m[1], num), true
+}
+
+//是否所有消费者都已处理完文件
+//fileName	输入文件名
+func (c *Consumers) Passed(fileName string) (bool, error) {
+	offsets, err := c.Offsets()
+	if err != nil {
+		return false, err
+	}
+	return c.passed(offsets, fileName), nil
+}
+
+func (c *Consumers) passed(offsets []*ConsumerOffset, fileName string) bool {
+	key, ok := c.reader.orderKey(fileName)
+	if !ok {
+		return true
+	}
+	for _, offset := range offsets {
+		if !offset.Committed {
+			return false
+		}
+		cur, err := c.reader.Resolve(offset.Position)
+		if err != nil { //无法定位所在文件, 不能确认已处理
+			return false
+		}
+		if curKey, ok := c.reader.orderKey(cur); ok && curKey <= key {
+			return false
+		}
+	}
+	return true
+}
+
+//读取消费者滞后信息
+func (c *Consumers) Lag() ([]*ConsumerLag, error) {
+	offsets, err := c.Offsets()
+	if err != nil {
+		return nil, err
+	}
+	files, err := c.reader.Files()
+	if err != nil {
+		return nil, err
+	}
+	sizes := make([]int64, len(files))
+	for i, f := range files {
+		if stat, err := os.Stat(f); err == nil {
+			sizes[i] = stat.Size()
+		}
+	}
+
+	lags := make([]*ConsumerLag, 0, len(offsets))
+	for _, offset := range offsets {
+		lag := &ConsumerLag{Name: offset.Name, Updated: offset.Updated, Files: len(files)}
+		index := -1
+		if offset.Committed {
+			lag.LineNo = offset.Position.LineNo
+			if cur, err := c.reader.Resolve(offset.Position); err == nil {
+				lag.FileName = cur
+				for i, f := range files {
+					if filepath.Clean(f) == filepath.Clean(cur) {
+						index = i
+						break
+					}
+				}
+			}
+		}
+		for i := index + 1; i < len(files); i++ {
+			lag.Bytes += sizes[i]
+		}
+		if index >= 0 {
+			lag.Files = len(files) - 1 - index
+			if rest := sizes[index] - offset.Position.Offset; rest > 0 {
+				lag.Bytes += rest
+			}
+		}
+		lags = append(lags, lag)
+	}
+	return lags, nil
+}
@@ -0,0 +1,661 @@
+// fileCrypt
+package fwrite
+
+import (
+	"archive/zip"
+	"bufio"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+const (
+	cryptMagic     = "FWCRYPT1" //加密文件信封头标识
+	cryptNonceSize = 12         //GCM随机数长度
+	cryptTagSize   = 16         //GCM认证标签长度
+	cryptChunkHead = 4          //密文块长度头
+	cryptChunkSize = 1 << 16    //流式加密块尺寸, 用于压缩文件
+
+	cryptMaxPlain = maxFrameSize - cryptTagSize //单个密文块最大明文尺寸
+	cryptSession  = 1 << 31                     //块长度头标志: 会话记录, 内容为新的随机数基值
+	cryptFinal    = 1 << 30                     //块长度头标志: 结束块, 标记写入会话完整结束
+	cryptLenMask  = cryptFinal - 1              //块长度头中的长度
+)
+
+var (
+	ErrKeyMiss        = errors.New("encrypt key miss")       //未提供密钥或密钥编号不存在
+	ErrCryptCorrupt   = errors.New("encrypt chunk corrupt")  //密文块认证失败
+	ErrCryptTruncated = errors.New("encrypt file truncated") //封存的加密文件未以结束块结束
+	errCryptAppend    = errors.New("file can't append encrypted chunk")
+)
+
+//加密密钥提供者, 按编号轮换密钥
+type KeyProvider interface {
+	//当前密钥编号和密钥, 用于新文件
+	CurrentKey() (id string, key []byte, err error)
+
+	//按编号读取密钥, 用于解密和续写已有文件
+	Key(id string) ([]byte, error)
+}
+
+//静态密钥表
+type StaticKeys struct {
+	Current string            //当前密钥编号
+	Keys    map[string][]byte //密钥编号到AES密钥(16/24/32字节)
+}
+
+func (k *StaticKeys) CurrentKey() (string, []byte, error) {
+	key, err := k.Key(k.Current)
+	return k.Current, key, err
+}
+
+func (k *StaticKeys) Key(id string) ([]byte, error) {
+	key, ok := k.Keys[id]
+	if !ok {
+		return nil, errorf("key \"%s\" %w", id, ErrKeyMiss)
+	}
+	return key, nil
+}
+
+//获取加密密钥提供者, 为空时不加密
+func (c *FileConfig) GetKeyProvider() KeyProvider {
+	return c.KeyProvider
+}
+
+//设置加密密钥提供者, 为空时不加密, 新文件使用当前密钥,
+//已有加密文件使用其信封头中的密钥续写
+func (w *FileWrite) SetKeyProvider(keys KeyProvider) {
+	w.mu.Lock()
+	w.cfg.KeyProvider = keys
+	w.mu.Unlock()
+}
+
+//设置解密密钥提供者
+func (r *Reader) SetKeyProvider(keys KeyProvider) {
+	r.keys = keys
+}
+
+//加密块流, 第n块的随机数为base与n异或,
+//每次打开文件续写时以会话记录更换base, 块序号从0开始,
+//附加认证数据含信封头摘要和会话序号, 密文块不能移到其它文件或其它会话
+type cryptStream struct {
+	aead  cipher.AEAD
+	base  [cryptNonceSize]byte
+	head  [sha256.Size]byte //信封头摘要
+	index uint32            //会话序号, 信封头为0
+	n     uint64            //块序号
+	size  int64             //明文尺寸
+}
+
+func newCryptStream(key []byte, base []byte) (*cryptStream, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	s := &cryptStream{aead: aead}
+	copy(s.base[:], base)
+	return s, nil
+}
+
+func (s *cryptStream) nonce() []byte {
+	nonce := s.base
+	binary.BigEndian.PutUint64(nonce[4:], binary.BigEndian.Uint64(nonce[4:])^s.n)
+	s.n++
+	return nonce[:]
+}
+
+//附加认证数据: 信封头摘要 + 会话序号 + 是否结束块
+//flag	输入块长度头标志
+func (s *cryptStream) aad(flag uint32) []byte {
+	aad := make([]byte, 0, len(s.head)+5)
+	aad = append(aad, s.head[:]...)
+	aad = binary.BigEndian.AppendUint32(aad, s.index)
+	if flag == cryptFinal {
+		return append(aad, 1)
+	}
+	return append(aad, 0)
+}
+
+//加密为一个密文块: 长度头 + 密文
+func (s *cryptStream) seal(plain []byte) []byte {
+	return s.sealChunk(plain, 0)
+}
+
+//加密为密文块, 超过最大块尺寸时分为多块
+func (s *cryptStream) sealAll(plain []byte) []byte {
+	if len(plain) <= cryptMaxPlain {
+		return s.seal(plain)
+	}
+	data := make([]byte, 0, len(plain)+(len(plain)/cryptMaxPlain+1)*(cryptChunkHead+cryptTagSize))
+	for len(plain) > 0 {
+		n := len(plain)
+		if n > cryptMaxPlain {
+			n = cryptMaxPlain
+		}
+		data = append(data, s.seal(plain[:n])...)
+		plain = plain[n:]
+	}
+	return data
+}
+
+//生成结束块, 明文为空, 标志计入附加认证数据,
+//结束块只出现在文件末尾, 续写时截断后再写入会话记录
+func (s *cryptStream) final() []byte {
+	return s.sealChunk(nil, cryptFinal)
+}
+
+func (s *cryptStream) sealChunk(plain []byte, flag uint32) []byte {
+	chunk := make([]byte, cryptChunkHead, cryptChunkHead+len(plain)+cryptTagSize)
+	chunk = s.aead.Seal(chunk, s.nonce(), plain, s.aad(flag))
+	binary.BigEndian.PutUint32(chunk, flag|uint32(len(chunk)-cryptChunkHead))
+	s.size += int64(len(plain))
+	return chunk
+}
+
+//开始新的写入会话, 生成新的随机数基值, 返回会话记录: 长度头 + 随机数基值
+func (s *cryptStream) session() ([]byte, error) {
+	if _, err := rand.Read(s.base[:]); err != nil {
+		return nil, err
+	}
+	s.n, s.index = 0, s.index+1
+	rec := binary.BigEndian.AppendUint32(make([]byte, 0, cryptChunkHead+cryptNonceSize),
+		cryptSession|cryptNonceSize)
+	return append(rec, s.base[:]...), nil
+}
+
+//生成信封头: 标识 + 密钥编号长度 + 密钥编号 + 随机数基值
+//keys	输入密钥提供者
+func newCryptHeader(keys KeyProvider) (header []byte, s *cryptStream, err error) {
+	id, key, err := keys.CurrentKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	base := make([]byte, cryptNonceSize)
+	if _, err = rand.Read(base); err != nil {
+		return nil, nil, err
+	}
+	if s, err = newCryptStream(key, base); err != nil {
+		return nil, nil, err
+	}
+	header = append(header, cryptMagic...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(id)))
+	header = append(header, id...)
+	header = append(header, base...)
+	s.head = sha256.Sum256(header)
+	return header, s, nil
+}
+
+//读取信封头, 非加密文件返回errCryptAppend
+//rd  	输入文件内容
+//keys	输入密钥提供者
+//s   	输出加密块流
+//size	输出信封头尺寸
+func readCryptHeader(rd io.Reader, keys KeyProvider) (s *cryptStream, size int, err error) {
+	var head [len(cryptMagic) + 2]byte
+	if _, err = io.ReadFull(rd, head[:]); err != nil || string(head[:len(cryptMagic)]) != cryptMagic {
+		return nil, 0, errCryptAppend
+	}
+	idLen := int(binary.BigEndian.Uint16(head[len(cryptMagic):]))
+	buf := make([]byte, idLen+cryptNonceSize)
+	if _, err = io.ReadFull(rd, buf); err != nil {
+		return nil, 0, errCryptAppend
+	}
+	if keys == nil {
+		return nil, 0, ErrKeyMiss
+	}
+	key, err := keys.Key(string(buf[:idLen]))
+	if err != nil {
+		return nil, 0, err
+	}
+	if s, err = newCryptStream(key, buf[idLen:]); err != nil {
+		return nil, 0, err
+	}
+	digest := sha256.New()
+	digest.Write(head[:])
+	digest.Write(buf)
+	digest.Sum(s.head[:0])
+	return s, len(head) + len(buf), nil
+}
+
+//是否加密文件
+func isCryptFile(fd io.ReaderAt) bool {
+	var magic [len(cryptMagic)]byte
+	_, err := fd.ReadAt(magic[:], 0)
+	return err == nil && string(magic[:]) == cryptMagic
+}
+
+//解密读取, 活动文件末尾未写完的密文块暂存, 返回io.ErrUnexpectedEOF,
+//封存文件未以结束块结束时返回ErrCryptTruncated,
+//结束块之后又有内容时文件已被续写, 回到结束块位置读取续写的会话记录
+type cryptReader struct {
+	io.Closer
+	rd      io.ReadSeeker
+	s       *cryptStream
+	plain   []byte //未读明文
+	raw     []byte //未完成密文块
+	buf     []byte //明文缓存
+	sealed  bool   //是否封存文件
+	final   int    //最后读取的结束块尺寸, 不是结束块为0
+	rewound bool   //是否已回到结束块位置, 之后必须是会话记录
+}
+
+func (r *cryptReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+//读取下一密文块
+func (r *cryptReader) next() error {
+	need := cryptChunkHead
+	var head uint32
+	if len(r.raw) >= cryptChunkHead {
+		head = binary.BigEndian.Uint32(r.raw)
+		l := head & cryptLenMask
+		switch {
+		case head&cryptSession != 0:
+			if l != cryptNonceSize {
+				return ErrCryptCorrupt
+			}
+		case r.rewound || l < cryptTagSize || l > maxFrameSize:
+			return ErrCryptCorrupt
+		}
+		need += int(l)
+	}
+	if len(r.raw) < need {
+		if cap(r.raw) < need {
+			r.raw = append(make([]byte, 0, need), r.raw...)
+		}
+		n, err := io.ReadFull(r.rd, r.raw[len(r.raw):need])
+		r.raw = r.raw[:len(r.raw)+n]
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if len(r.raw) == 0 {
+				if r.sealed && r.final == 0 {
+					return ErrCryptTruncated
+				}
+				return io.EOF
+			}
+			if r.final > 0 {
+				return r.rewind()
+			}
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		if r.final > 0 {
+			return r.rewind()
+		}
+		if need == cryptChunkHead {
+			return r.next()
+		}
+	}
+
+	if head&cryptSession != 0 { //新的写入会话
+		copy(r.s.base[:], r.raw[cryptChunkHead:need])
+		r.s.n, r.s.index, r.raw, r.rewound = 0, r.s.index+1, r.raw[:0], false
+		return r.next()
+	}
+	plain, err := r.s.aead.Open(r.buf[:0], r.s.nonce(), r.raw[cryptChunkHead:need], r.s.aad(head&cryptFinal))
+	if err != nil {
+		return ErrCryptCorrupt
+	}
+	r.plain, r.buf, r.raw, r.final = plain, plain, r.raw[:0], 0
+	if head&cryptFinal != 0 {
+		r.final = need
+	}
+	return nil
+}
+
+//结束块之后读到新内容, 回到结束块位置继续读取
+func (r *cryptReader) rewind() error {
+	if _, err := r.rd.Seek(-int64(len(r.raw)+r.final), io.SeekCurrent); err != nil {
+		return err
+	}
+	r.raw, r.final, r.rewound = r.raw[:0], 0, true
+	return r.next()
+}
+
+//打开文件读取明文, 加密文件解密读取
+//fileName	输入文件名
+//keys    	输入密钥提供者
+func openPlain(fileName string, keys KeyProvider) (io.ReadCloser, error) {
+	fd, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if !isCryptFile(fd) {
+		return fd, nil
+	}
+	s, _, err := readCryptHeader(fd, keys)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return &cryptReader{Closer: fd, rd: fd, s: s}, nil
+}
+
+//获取明文读取的文件句柄, 压缩文件返回nil
+//rc	输入明文读取
+func fileOf(rc io.Reader) *os.File {
+	switch rc := rc.(type) {
+	case *os.File:
+		return rc
+	case *cryptReader:
+		fd, _ := rc.Closer.(*os.File)
+		return fd
+	}
+	return nil
+}
+
+//跳过明文, 非加密文件直接定位
+//rc 	输入明文读取
+//off	输入明文偏移
+func skipPlain(rc io.Reader, off int64) error {
+	if fd, ok := rc.(*os.File); ok {
+		_, err := fd.Seek(off, io.SeekStart)
+		return err
+	}
+	_, err := io.CopyN(io.Discard, rc, off)
+	return err
+}
+
+const (
+	zipLocalSig      = 0x04034b50 //本地文件头标识
+	zipDescriptorSig = 0x08074b50 //数据描述符标识
+	zipLocalSize     = 30         //本地文件头固定部分尺寸
+	zipDescriptor    = 0x8        //通用标志: CRC和尺寸在数据描述符中
+)
+
+//打开加密压缩文件, 解密后流式解压其中第一个文件, 不在内存中缓存整个文件
+//fd  	输入已打开的加密压缩文件
+//keys	输入密钥提供者
+func openCryptZip(fd *os.File, keys KeyProvider) (io.ReadCloser, error) {
+	s, _, err := readCryptHeader(fd, keys)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	br := bufio.NewReaderSize(&cryptReader{rd: fd, s: s, sealed: true}, 32768) // 32k
+	var head [zipLocalSize]byte
+	if _, err = io.ReadFull(br, head[:]); err != nil {
+		fd.Close()
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(head[:]) != zipLocalSig {
+		fd.Close()
+		return nil, errorf("zip file \"%s\" is empty", fd.Name())
+	}
+	if method := binary.LittleEndian.Uint16(head[8:]); method != zip.Deflate {
+		fd.Close()
+		return nil, errorf("zip file \"%s\" method %d: %w", fd.Name(), method, zip.ErrAlgorithm)
+	}
+	skip := int64(binary.LittleEndian.Uint16(head[26:])) + int64(binary.LittleEndian.Uint16(head[28:]))
+	if _, err = io.CopyN(io.Discard, br, skip); err != nil {
+		fd.Close()
+		return nil, err
+	}
+	z := &cryptZipReader{Closer: fd, br: br, fr: flate.NewReader(br), crc: crc32.NewIEEE()}
+	z.desc = binary.LittleEndian.Uint16(head[6:])&zipDescriptor != 0
+	z.sum = binary.LittleEndian.Uint32(head[14:])
+	return z, nil
+}
+
+//加密压缩文件流式解压, 解压结束后校验CRC并读完剩余密文,
+//以便在文件末尾截断时返回ErrCryptTruncated
+type cryptZipReader struct {
+	io.Closer
+	br   *bufio.Reader //解密内容
+	fr   io.ReadCloser //解压内容
+	crc  hash.Hash32   //解压内容CRC
+	sum  uint32        //本地文件头中的CRC
+	desc bool          //CRC是否在数据描述符中
+	err  error
+}
+
+func (z *cryptZipReader) Read(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	n, err := z.fr.Read(p)
+	z.crc.Write(p[:n])
+	if err == io.EOF {
+		err = z.finish()
+	}
+	z.err = err
+	return n, err
+}
+
+//校验CRC, 读完数据描述符和中央目录
+func (z *cryptZipReader) finish() error {
+	sum := z.sum
+	if z.desc {
+		var desc [8]byte
+		if _, err := io.ReadFull(z.br, desc[:]); err != nil {
+			return err
+		}
+		if binary.LittleEndian.Uint32(desc[:]) != zipDescriptorSig {
+			return zip.ErrFormat
+		}
+		sum = binary.LittleEndian.Uint32(desc[4:])
+	}
+	if sum != z.crc.Sum32() {
+		return zip.ErrChecksum
+	}
+	if _, err := io.Copy(io.Discard, z.br); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+func (z *cryptZipReader) Close() error {
+	z.fr.Close()
+	return z.Closer.Close()
+}
+
+//流式加密写入, 按块尺寸分块
+type cryptWriter struct {
+	wt  io.Writer
+	s   *cryptStream
+	buf []byte
+}
+
+func newCryptWriter(wt io.Writer, keys KeyProvider) (*cryptWriter, error) {
+	header, s, err := newCryptHeader(keys)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = wt.Write(header); err != nil {
+		return nil, err
+	}
+	return &cryptWriter{wt: wt, s: s, buf: make([]byte, 0, cryptChunkSize)}, nil
+}
+
+func (w *cryptWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		m := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf, p, n = w.buf[:len(w.buf)+m], p[m:], n+m
+		if len(w.buf) == cap(w.buf) {
+			if err = w.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *cryptWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := w.wt.Write(w.s.seal(w.buf))
+	w.buf = w.buf[:0]
+	return err
+}
+
+//写入剩余数据和结束块
+func (w *cryptWriter) close() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	_, err := w.wt.Write(w.s.final())
+	return err
+}
+
+//打开文件准备加密写入(已持有锁), 新文件写入信封头,
+//已有加密文件截断不完整的密文块和末尾的结束块后写入会话记录, 以新的随机数基值续写
+//fd      	输入已打开文件
+//fileName	输入文件名
+//fileSize	输入文件尺寸
+//size    	输出明文尺寸
+//err     	输出错误信息, 已有文件无法续写时为errCryptAppend
+func (mw *MutexWrite) openCrypt(fd *os.File, fileName string, fileSize int64) (size int64, err error) {
+	mw.crypt = nil
+	keys := mw.cfger.GetKeyProvider()
+	if keys == nil {
+		return fileSize, nil
+	}
+
+	if fileSize == 0 {
+		header, s, err := newCryptHeader(keys)
+		if err == nil {
+			_, err = fd.Write(header)
+		}
+		if err != nil {
+			mw.log(LevelError, "encrypt", fileName, err)
+			return 0, err
+		}
+		mw.crypt = s
+		return 0, nil
+	}
+
+	src := io.NewSectionReader(fd, 0, fileSize)
+	s, offset, err := readCryptHeader(src, keys)
+	if err != nil {
+		mw.log(LevelError, "encrypt", fileName, err)
+		return 0, errCryptAppend
+	}
+
+	//只读取块长度头, 统计明文尺寸和会话数
+	var head [cryptChunkHead]byte
+	valid, final := int64(offset), int64(-1)
+	for valid+cryptChunkHead <= fileSize {
+		if _, err = fd.ReadAt(head[:], valid); err != nil {
+			break
+		}
+		h := binary.BigEndian.Uint32(head[:])
+		l := int64(h & cryptLenMask)
+		if h&cryptSession != 0 {
+			if l != cryptNonceSize || valid+cryptChunkHead+l > fileSize {
+				break
+			}
+			valid, final = valid+cryptChunkHead+l, -1
+			s.index++
+			continue
+		}
+		if l < cryptTagSize || l > maxFrameSize || valid+cryptChunkHead+l > fileSize {
+			break
+		}
+		final = -1
+		if h&cryptFinal != 0 {
+			final = valid
+		}
+		valid += cryptChunkHead + l
+		s.size += l - cryptTagSize
+	}
+	if valid < fileSize {
+		mw.log(LevelWarn, "truncate torn chunk", fileName, nil,
+			Field{"bytes", fileSize - valid}, Field{"offset", valid})
+	}
+	//结束块只保留在文件末尾, 在会话边界截断封存文件时读取可发现
+	if final >= 0 {
+		valid = final
+	}
+	if valid < fileSize {
+		if err = fd.Truncate(valid); err != nil {
+			return 0, err
+		}
+	}
+
+	//截断位置之后的块序号可能已用过, 不能续用原随机数基值
+	rec, err := s.session()
+	if err == nil {
+		_, err = fd.Write(rec)
+	}
+	if err != nil {
+		mw.log(LevelError, "encrypt", fileName, err)
+		return 0, err
+	}
+	mw.crypt = s
+	return s.size, nil
+}
+
+//写入加密结束块(已持有锁), 在关闭文件前调用, 读取时据此发现在块边界的截断
+func (mw *MutexWrite) finishCrypt() {
+	if mw.crypt == nil || mw.closed || mw.file == nil {
+		return
+	}
+	chunk := mw.crypt.final()
+	if _, err := mw.file.Write(chunk); err != nil {
+		mw.log(LevelError, "encrypt", mw.file.Name(), err)
+		return
+	}
+	mw.stats.addFile(nil, chunk)
+}
+
+//写入文件(已持有锁), 加密时整体作为一个密文块, 超过最大块尺寸时分为多块
+func (mw *MutexWrite) writeFile(b []byte) (int, error) {
+	if mw.crypt == nil {
+		n, err := mw.file.Write(b)
+		mw.stats.addFile(b[:n], b[:n])
+		return n, err
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	data := mw.crypt.sealAll(b)
+	if _, err := mw.file.Write(data); err != nil {
+		return 0, err
+	}
+	mw.stats.addFile(b, data)
+	return len(b), nil
+}
+
+//当前文件明文尺寸
+func (mw *MutexWrite) FileSize() (int64, error) {
+	if mw == nil {
+		return 0, ErrFileNil
+	}
+
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
+	if mw.closed || mw.file == nil || mw.file == os.Stdout {
+		return 0, ErrFileClosed
+	}
+	if mw.crypt != nil {
+		return mw.crypt.size, nil
+	}
+	stat, err := mw.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
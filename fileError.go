@@ -0,0 +1,191 @@
+// fileError
+package fwrite
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+//错误回调函数, 内部操作失败时调用, 在出错的Go程中执行
+type ErrorFunc func(err error)
+
+//文件旋转错误
+type RotateError struct {
+	FileName string //旋转前文件名
+	Err      error  //旋转错误
+}
+
+func (e *RotateError) Error() string {
+	return sprintf("rotate \"%s\": %v", e.FileName, e.Err)
+}
+
+func (e *RotateError) Unwrap() error {
+	return e.Err
+}
+
+//文件重命名错误
+type RenameError struct {
+	From string //原文件名
+	To   string //重命名文件名, 未获取到为空
+	Err  error  //重命名错误
+}
+
+func (e *RenameError) Error() string {
+	return sprintf("rename \"%s\" -> \"%s\": %v", e.From, e.To, e.Err)
+}
+
+func (e *RenameError) Unwrap() error {
+	return e.Err
+}
+
+//文件压缩错误
+type CompressError struct {
+	FileName string //压缩文件名
+	Err      error  //压缩错误
+}
+
+func (e *CompressError) Error() string {
+	return sprintf("compress \"%s\": %v", e.FileName, e.Err)
+}
+
+func (e *CompressError) Unwrap() error {
+	return e.Err
+}
+
+//文件清理错误
+type CleanError struct {
+	Path string //清理路径
+	Op   string //清理操作
+	Err  error  //清理错误
+}
+
+func (e *CleanError) Error() string {
+	return sprintf("clean %s \"%s\": %v", e.Op, e.Path, e.Err)
+}
+
+func (e *CleanError) Unwrap() error {
+	return e.Err
+}
+
+//错误计数
+type ErrorStats struct {
+	Rotate   uint64 //旋转错误数
+	Rename   uint64 //重命名错误数
+	Compress uint64 //压缩错误数
+	Lock     uint64 //锁定错误数
+	Clean    uint64 //清理错误数
+	Other    uint64 //其它错误数
+}
+
+//设置错误回调函数, 须在Init前设置
+//onError	输入错误回调函数, 为空时不回调
+func (w *FileWrite) SetOnError(onError ErrorFunc) {
+	w.mu.Lock()
+	w.cfg.OnError = onError
+	w.mu.Unlock()
+}
+
+//读取错误计数, 计数持续增长说明旋转、压缩或清理已失效
+func (w *FileWrite) Errors() ErrorStats {
+	return w.cfg.Errors()
+}
+
+//读取错误计数
+func (c *FileConfig) Errors() ErrorStats {
+	return ErrorStats{
+		Rotate:   atomic.LoadUint64(&c.errs.Rotate),
+		Rename:   atomic.LoadUint64(&c.errs.Rename),
+		Compress: atomic.LoadUint64(&c.errs.Compress),
+		Lock:     atomic.LoadUint64(&c.errs.Lock),
+		Clean:    atomic.LoadUint64(&c.errs.Clean),
+		Other:    atomic.LoadUint64(&c.errs.Other),
+	}
+}
+
+//报告错误: 按类型计数并调用错误回调
+//err	输入错误
+func (c *FileConfig) reportError(err error) {
+	var (
+		rotateErr   *RotateError
+		renameErr   *RenameError
+		compressErr *CompressError
+		lockErr     *LockError
+		cleanErr    *CleanError
+	)
+	switch {
+	case errors.As(err, &rotateErr):
+		atomic.AddUint64(&c.errs.Rotate, 1)
+	case errors.As(err, &renameErr):
+		atomic.AddUint64(&c.errs.Rename, 1)
+	case errors.As(err, &compressErr):
+		atomic.AddUint64(&c.errs.Compress, 1)
+	case errors.As(err, &lockErr):
+		atomic.AddUint64(&c.errs.Lock, 1)
+	case errors.As(err, &cleanErr):
+		atomic.AddUint64(&c.errs.Clean, 1)
+	default:
+		atomic.AddUint64(&c.errs.Other, 1)
+	}
+	if c.OnError != nil {
+		c.OnError(err)
+	}
+}
+
+//输出错误日志并报告错误
+//op  	输入操作
+//file	输入文件名
+//err 	输入错误
+func (w *FileWrite) fail(op, file string, err error, fields ...Field) error {
+	w.log(LevelError, op, file, err, fields...)
+	w.cfger.reportError(err)
+	return err
+}
+
+//输出错误日志并报告错误
+//op  	输入操作
+//file	输入文件名
+//err 	输入错误
+func (mw *MutexWrite) fail(op, file string, err error, fields ...Field) error {
+	mw.log(LevelError, op, file, err, fields...)
+	mw.cfger.reportError(err)
+	return err
+}
+
+//输出错误日志并报告错误
+//op  	输入操作
+//file	输入文件名
+//err 	输入错误
+func (c *FileConfig) fail(op, file string, err error, fields ...Field) error {
+	c.log(LevelError, op, file, err, fields...)
+	c.reportError(err)
+	return err
+}
+
+//压缩文件并更新校验清单, 失败时输出日志并报告CompressError
+//cfger   	输入配置接口
+//name    	输入写入器名称
+//fileName	输入压缩文件名
+func zipReport(cfger MutexConfiger, name, fileName string) {
+	if err := zipLogFile(fileName, cfger.GetKeyProvider()); err != nil {
+		err = &CompressError{FileName: fileName, Err: err}
+		logEvent(cfger.GetLogger(), LevelError, name, "compress", fileName, err)
+		cfger.reportError(err)
+		return
+	}
+	if err := zipManifest(fileName); err != nil {
+		err = &CompressError{FileName: fileName, Err: errorf("update manifest: %w", err)}
+		logEvent(cfger.GetLogger(), LevelError, name, "zip manifest", fileName, err)
+		cfger.reportError(err)
+	}
+	cfger.fireEvent(Event{Type: EventCompress, Name: name, FileName: fileName,
+		NewName: fileName + zipFileSuffix})
+}
+
+//创建锁定错误, 包含锁文件中记录的持有者
+//lockName	输入锁文件名
+//err     	输入锁定错误
+func newLockError(lockName string, err error) *LockError {
+	owner := readLockOwner(lockName)
+	return &LockError{FileName: lockName, Pid: owner.Pid, Host: owner.Host,
+		Name: owner.Name, Start: owner.Start, Err: err}
+}
@@ -0,0 +1,182 @@
+// fileEvent
+package fwrite
+
+import (
+	"os"
+	"time"
+)
+
+//生命周期事件类型
+type EventType int
+
+const (
+	EventOpen        EventType = iota //打开文件
+	EventRotateStart                  //旋转开始
+	EventRotate                       //旋转完成
+	EventSeal                         //文件封存(重命名)
+	EventCompress                     //文件压缩完成
+	EventClean                        //保留期清理删除文件
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventOpen:
+		return "open"
+	case EventRotateStart:
+		return "rotate-start"
+	case EventRotate:
+		return "rotate"
+	case EventSeal:
+		return "seal"
+	case EventCompress:
+		return "compress"
+	case EventClean:
+		return "clean"
+	}
+	return sprintf("event(%d)", int(t))
+}
+
+//旋转原因
+const (
+	RotateLines  = "lines"  //达到最大行数
+	RotateSize   = "size"   //达到最大尺寸
+	RotateDay    = "day"    //日期变化
+	RotateBatch  = "batch"  //整批写入超出当前文件
+	RotateManual = "manual" //调用Rotate
+)
+
+//生命周期事件
+type Event struct {
+	Type     EventType  //事件类型
+	Name     string     //写入器名称
+	FileName string     //事件文件: 打开、封存前、压缩前或删除的文件, 旋转时为旋转前活动文件
+	NewName  string     //新文件名: 封存后文件、压缩文件, 旋转完成时为新活动文件
+	Sealed   string     //旋转完成时的封存文件名, 未重命名为空
+	Reason   string     //旋转原因: lines, size, day, batch, manual
+	Size     int64      //文件尺寸: 旋转时为旋转前文件尺寸
+	Lines    int64      //文件行数: 旋转时为旋转前文件行数
+	Stats    *FileStats //封存和旋转完成时的文件统计, 未启用统计为nil
+	Time     time.Time  //事件时间
+}
+
+//事件回调函数
+//	在触发事件的Go程中持有写入器锁执行, 不能调用同一写入器的方法, 耗时操作应转交其它Go程
+type EventFunc func(ev Event)
+
+//生命周期事件回调, 为空时不回调
+type Hooks struct {
+	OnOpen     EventFunc //打开文件
+	OnRotate   EventFunc //旋转开始和完成
+	OnSeal     EventFunc //文件封存
+	OnCompress EventFunc //文件压缩完成
+	OnClean    EventFunc //保留期清理删除文件
+}
+
+//设置生命周期事件回调, 须在Init前设置
+func (w *FileWrite) SetHooks(hooks Hooks) {
+	w.mu.Lock()
+	w.cfg.Hooks = hooks
+	w.mu.Unlock()
+}
+
+//设置生命周期事件通道, 须在Init前设置
+//	事件以非阻塞方式发送, 通道满时丢弃事件, 接收方应及时读取或使用带缓冲通道
+//events	输入事件通道, 为空时不发送
+func (w *FileWrite) SetEvents(events chan<- Event) {
+	w.mu.Lock()
+	w.cfg.Events = events
+	w.mu.Unlock()
+}
+
+//触发生命周期事件: 调用对应回调并发送到事件通道
+//ev	输入事件
+func (c *FileConfig) fireEvent(ev Event) {
+	if ev.Name == "" {
+		ev.Name = c.Name
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	c.metrics.countEvent(&ev)
+
+	var hook EventFunc
+	switch ev.Type {
+	case EventOpen:
+		hook = c.Hooks.OnOpen
+	case EventRotateStart, EventRotate:
+		hook = c.Hooks.OnRotate
+	case EventSeal:
+		hook = c.Hooks.OnSeal
+	case EventCompress:
+		hook = c.Hooks.OnCompress
+	case EventClean:
+		hook = c.Hooks.OnClean
+	}
+	if hook != nil {
+		hook(ev)
+	}
+	if c.Events != nil {
+		select {
+		case c.Events <- ev:
+		default:
+			c.log(LevelWarn, "event dropped", ev.FileName, nil, Field{"event", ev.Type.String()})
+		}
+	}
+}
+
+//获取当前文件统计快照(已持有锁), 未启用统计返回nil
+//nextName	输入下一文件名
+func (mw *MutexWrite) statsSnapshot(nextName string) *FileStats {
+	if !mw.stats.on || mw.closed {
+		return nil
+	}
+	return &FileStats{
+		FileName:  mw.file.Name(),
+		NextName:  nextName,
+		Records:   mw.stats.records,
+		Bytes:     mw.stats.bytes,
+		FirstTime: mw.stats.first,
+		LastTime:  mw.stats.last,
+		Sha256:    mw.stats.hash.Sum(nil),
+		Chain:     mw.chain.prevHex(),
+	}
+}
+
+//触发文件封存事件并记录封存结果(已持有锁)
+//fileName  	输入封存前文件名
+//fileRename	输入封存后文件名
+//stats     	输入封存文件统计, 未启用为nil
+func (mw *MutexWrite) sealEvent(fileName, fileRename string, stats *FileStats) {
+	ev := Event{Type: EventSeal, FileName: fileName,
+		NewName: fileRename, Stats: stats}
+	if info, err := os.Stat(fileRename); err == nil {
+		ev.Size = info.Size()
+	}
+	if stats != nil {
+		ev.Lines = stats.Records
+	}
+	mw.sealed = &ev
+	mw.cfger.fireEvent(ev)
+}
+
+//触发打开文件事件(已持有锁)
+//fileName	输入文件名
+//fileSize	输入文件尺寸
+func (mw *MutexWrite) openEvent(fileName string, fileSize int64) {
+	mw.cfger.fireEvent(Event{Type: EventOpen,
+		FileName: fileName, Size: fileSize})
+}
+
+//读取并清除最近一次封存结果(Go程安全)
+//fileName	输出封存后文件名, 未封存为空
+//stats   	输出封存文件统计
+func (mw *MutexWrite) takeSealed() (fileName string, stats *FileStats) {
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
+	if mw.sealed != nil {
+		fileName, stats = mw.sealed.NewName, mw.sealed.Stats
+		mw.sealed = nil
+	}
+	return
+}
@@ -0,0 +1,139 @@
+// fileFollow
+package fwrite
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	followPoll = 200 * time.Millisecond //跟踪读取轮询间隔
+)
+
+//文件变化监视
+type followWatcher interface {
+	//等待目录变化, 超时也返回以便重新检查
+	Wait(ctx context.Context) error
+
+	//关闭监视
+	Close() error
+}
+
+//轮询监视
+type pollWatcher struct{}
+
+func (pollWatcher) Wait(ctx context.Context) error {
+	t := time.NewTimer(followPoll)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func (pollWatcher) Close() error {
+	return nil
+}
+
+//跟踪读取记录(tail -F), 读完已有记录后持续读取新记录,
+//活动文件被重命名或压缩时读完旧文件再切换到新活动文件
+//ctx	输入取消上下文
+//fn 	输入记录处理函数, 返回错误时停止跟踪
+func (r *Reader) Follow(ctx context.Context, fn func(rec *Record) error) error {
+	watcher, err := newFollowWatcher(filepath.Dir(r.activeName()))
+	if err != nil {
+		watcher = pollWatcher{}
+	}
+	defer watcher.Close()
+
+	r.follow = true
+	defer func() { r.follow = false }()
+
+	for {
+		for r.Next() {
+			if err := fn(&r.rec); err != nil {
+				return err
+			}
+		}
+		if r.err != nil {
+			return r.err
+		}
+
+		switch {
+		case r.rc != nil && r.live && r.rotated():
+			//活动文件已被重命名, 继续读完旧文件
+			r.live, r.name = false, r.sealedName()
+			if cr, ok := r.rc.(*cryptReader); ok {
+				cr.sealed = true
+			}
+			continue
+		case r.rc == nil && r.index == len(r.files)-1 && r.relist():
+			//读完旧文件, 继续读取之后封存的文件和新活动文件
+			continue
+		}
+
+		if err := watcher.Wait(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+//重新列出文件, 从已读文件之后继续读取, 有待读文件返回true
+func (r *Reader) relist() bool {
+	files, err := r.Files()
+	if err != nil {
+		return false
+	}
+	name := strings.TrimSuffix(r.name, zipFileSuffix)
+	for i, file := range files {
+		if file != r.activeName() && strings.TrimSuffix(file, zipFileSuffix) == name {
+			if i == len(files)-1 {
+				return false
+			}
+			r.files, r.index = files, i
+			return true
+		}
+	}
+	//已读文件找不到(如已清理), 切换到新活动文件
+	if !FileExist(r.activeName()) {
+		return false
+	}
+	r.files = append(r.files, r.activeName())
+	return true
+}
+
+//当前活动文件是否已被重命名
+func (r *Reader) rotated() bool {
+	if r.fd == nil {
+		return false
+	}
+	cur, err := r.fd.Stat()
+	if err != nil {
+		return false
+	}
+	active, err := os.Stat(r.activeName())
+	return err != nil || !os.SameFile(cur, active)
+}
+
+//查找当前文件重命名后的文件名, 找不到(如已压缩)则返回原文件名
+func (r *Reader) sealedName() string {
+	if r.fd == nil {
+		return r.name
+	}
+	cur, err := r.fd.Stat()
+	if err != nil {
+		return r.name
+	}
+	files, _ := r.Files()
+	for i := len(files) - 1; i >= 0; i-- {
+		if stat, err := os.Stat(files[i]); err == nil && os.SameFile(cur, stat) {
+			return files[i]
+		}
+	}
+	return r.name
+}
@@ -0,0 +1,115 @@
+// fileFollow
+package fwrite
+
+import (
+	"context"
+	"sync"
+	"syscall"
+	"time"
+)
+
+//inotify监视
+type inotifyWatcher struct {
+	fd   int    //inotify描述符
+	epfd int    //epoll描述符
+	wake [2]int //取消唤醒管道, 取消时写入使EpollWait立即返回
+	buf  []byte
+}
+
+//创建目录监视, 使用inotify
+//dir	输入监视目录
+func newFollowWatcher(dir string) (followWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	const mask = syscall.IN_MODIFY | syscall.IN_CREATE | syscall.IN_DELETE |
+		syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_CLOSE_WRITE
+	if _, err = syscall.InotifyAddWatch(fd, dir, mask); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	w := &inotifyWatcher{fd: fd, epfd: epfd, buf: make([]byte, 4096)}
+	if err = syscall.Pipe2(w.wake[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		syscall.Close(epfd)
+		syscall.Close(fd)
+		return nil, err
+	}
+	for _, efd := range []int{fd, w.wake[0]} {
+		event := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(efd)}
+		if err = syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, efd, &event); err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+func (w *inotifyWatcher) Wait(ctx context.Context) error {
+	//取消时写入唤醒管道, 返回前等待唤醒Go程退出
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case <-ctx.Done():
+			syscall.Write(w.wake[1], []byte{0})
+		case <-done:
+		}
+	}()
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+
+	events := make([]syscall.EpollEvent, 2)
+	deadline := time.Now().Add(5 * followPoll)
+	for time.Now().Before(deadline) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := syscall.EpollWait(w.epfd, events, int(followPoll/time.Millisecond))
+		if err != nil && err != syscall.EINTR {
+			return err
+		}
+		if n < 0 {
+			n = 0
+		}
+		changed := false
+		for _, event := range events[:n] {
+			if int(event.Fd) == w.wake[0] {
+				drainFd(w.wake[0], w.buf)
+			} else {
+				changed = true
+			}
+		}
+		if changed {
+			//读空事件, 由调用者重新检查文件
+			drainFd(w.fd, w.buf)
+			return nil
+		}
+	}
+	return nil
+}
+
+//读空非阻塞描述符
+func drainFd(fd int, buf []byte) {
+	for {
+		if _, err := syscall.Read(fd, buf); err != nil {
+			return
+		}
+	}
+}
+
+func (w *inotifyWatcher) Close() error {
+	syscall.Close(w.wake[0])
+	syscall.Close(w.wake[1])
+	syscall.Close(w.epfd)
+	return syscall.Close(w.fd)
+}
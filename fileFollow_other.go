@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+// fileFollow
+package fwrite
+
+//创建目录监视, 非Linux系统使用轮询
+//dir	输入监视目录
+func newFollowWatcher(dir string) (followWatcher, error) {
+	return pollWatcher{}, nil
+}
@@ -0,0 +1,167 @@
+// fileFrame
+package fwrite
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	frameHead    = 8       //帧头尺寸: 长度4字节 + CRC32C校验4字节
+	maxFrameSize = 1 << 26 //最大帧数据尺寸64M
+)
+
+var (
+	ErrFrameTorn    = fmt.Errorf("frame is torn")
+	ErrFrameCorrupt = fmt.Errorf("frame is corrupt")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+//帧错误, 记录出错文件和偏移
+type FrameError struct {
+	FileName string //文件名
+	Offset   int64  //帧偏移
+	Err      error  //ErrFrameTorn 或 ErrFrameCorrupt
+}
+
+func (e *FrameError) Error() string {
+	return sprintf("%s at \"%s\" offset %d", e.Err, e.FileName, e.Offset)
+}
+
+func (e *FrameError) Unwrap() error {
+	return e.Err
+}
+
+//是否二进制帧模式
+func (c *FileConfig) IsFrameMode() bool {
+	return c.FrameMode
+}
+
+//整理记录数据, 帧模式时封装为帧, 行号为帧序号
+//in    	输入记录数据
+//out   	输出整理后数据
+//lines 	输出记录行数
+//err   	输出错误信息
+func (c *FileConfig) record(in []byte) (out []byte, lines int64, err error) {
+	if !c.FrameMode {
+		return c.lineRecord(in)
+	}
+	if len(in) > maxFrameSize {
+		return nil, 0, errorf("frame size %d over %d", len(in), maxFrameSize)
+	}
+	return appendFrame(make([]byte, 0, frameHead+len(in)), in), 1, nil
+}
+
+//封装帧: [长度][CRC32C][数据]
+//dst    	输入目标缓存
+//payload	输入帧数据
+func appendFrame(dst, payload []byte) []byte {
+	var head [frameHead]byte
+	binary.BigEndian.PutUint32(head[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(head[4:], crc32.Checksum(payload, crcTable))
+	return append(append(dst, head[:]...), payload...)
+}
+
+//帧模式时封装帧(已持有锁), 用于文件头和文件结束填充
+func (mw *MutexWrite) frameBytes(b []byte) []byte {
+	if len(b) == 0 || !mw.cfger.IsFrameMode() {
+		return b
+	}
+	return appendFrame(make([]byte, 0, frameHead+len(b)), b)
+}
+
+//读取帧, 未读完的帧保存在partial中
+//br     	输入读取缓存
+//partial	输入输出未完成帧数据
+//payload	输出帧数据
+//size   	输出帧尺寸
+//err    	输出io.EOF, io.ErrUnexpectedEOF(帧不完整)或ErrFrameCorrupt
+func readFrame(br *bufio.Reader, partial *[]byte) (payload []byte, size int, err error) {
+	buf := *partial
+	for {
+		need := frameHead
+		if len(buf) >= frameHead {
+			l := binary.BigEndian.Uint32(buf[:4])
+			if l > maxFrameSize {
+				return nil, 0, ErrFrameCorrupt
+			}
+			need += int(l)
+		}
+		if len(buf) >= need {
+			break
+		}
+		if cap(buf) < need {
+			buf = append(make([]byte, 0, need), buf...)
+		}
+		n, e := io.ReadFull(br, buf[len(buf):need])
+		buf = buf[:len(buf)+n]
+		*partial = buf
+		if e == io.EOF || e == io.ErrUnexpectedEOF {
+			if len(buf) == 0 {
+				return nil, 0, io.EOF
+			}
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		if e != nil {
+			return nil, 0, e
+		}
+	}
+
+	size = len(buf)
+	payload = buf[frameHead:size]
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(buf[4:frameHead]) {
+		return nil, 0, ErrFrameCorrupt
+	}
+	*partial = nil
+	return payload, size, nil
+}
+
+//遍历帧
+//rd	输入数据
+//fn	输入帧处理函数
+//count	输出完整帧数
+//valid	输出完整帧尺寸
+//err  	输出错误信息, 帧不完整或损坏时返回ErrFrameTorn或ErrFrameCorrupt
+func walkFrames(rd io.Reader, fn func(size int)) (count, valid int64, err error) {
+	br := bufio.NewReaderSize(rd, 32768) // 32k
+	var partial []byte
+	for {
+		_, size, e := readFrame(br, &partial)
+		switch e {
+		case nil:
+			count++
+			valid += int64(size)
+			if fn != nil {
+				fn(size)
+			}
+			continue
+		case io.EOF:
+			return count, valid, nil
+		case io.ErrUnexpectedEOF:
+			return count, valid, ErrFrameTorn
+		default:
+			return count, valid, e
+		}
+	}
+}
+
+//获取文件帧数
+//fileName	输入文件名
+//keys    	输入解密密钥提供者
+func getFileFrames(fileName string, keys KeyProvider) (int64, error) {
+	fd, err := openPlain(fileName, keys)
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+
+	count, valid, err := walkFrames(fd, nil)
+	if err != nil {
+		return count, &FrameError{FileName: fileName, Offset: valid, Err: err}
+	}
+	return count, nil
+}
@@ -0,0 +1,146 @@
+// fileHeader
+package fwrite
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HeadSuffix = ".head" //文件头行数文件后缀, 文件头不计入行数时记录
+)
+
+var (
+	hostName, _ = os.Hostname()
+)
+
+//文件头信息
+type HeaderInfo struct {
+	Name     string    //记录器名称
+	Host     string    //主机名称
+	Pid      int       //进程号
+	OpenTime time.Time //文件打开时间
+	FileName string    //当前文件名
+	PrevName string    //上一文件名
+	Seq      uint64    //文件首个全局序号, 未分配为0
+	Chain    string    //哈希链前值, 未启用为空
+}
+
+//文件头生成函数
+type HeaderFunc func(info *HeaderInfo) []byte
+
+//设置文件开始填充
+func (c *FileConfig) SetFileHeader(fileHeader []byte) {
+	c.FileHeader = fileHeader
+}
+
+//设置文件开始生成函数
+func (c *FileConfig) SetHeaderFunc(headerFunc HeaderFunc) {
+	c.HeaderFunc = headerFunc
+}
+
+//获取文件开始填充, 优先使用生成函数
+func (c *FileConfig) GetFileHeader(info *HeaderInfo) []byte {
+	if c.HeaderFunc == nil {
+		return c.FileHeader
+	}
+	info.Name, info.Host, info.Pid = c.Name, hostName, os.Getpid()
+	if c.SeqMode {
+		info.Seq = c.CurSeq + 1
+	}
+	return c.HeaderFunc(info)
+}
+
+//文件头是否计入行数
+func (c *FileConfig) IsHeaderLines() bool {
+	return c.HeaderLines
+}
+
+func (c *FileConfig) setCurHeader(headLines int64) {
+	c.HeadLines = headLines
+}
+
+//获取文件头行数
+//fileName	输入文件名
+func (c *FileConfig) fileHeadLines(fileName string) int64 {
+	if c.HeadLines > 0 {
+		return c.HeadLines
+	}
+	//写入文件头时记录的行数, 生成的文件头只能由此识别
+	if lines, ok := loadHeadLines(fileName); ok {
+		return lines
+	}
+	if c.HeaderFunc != nil || len(c.FileHeader) == 0 {
+		return 0
+	}
+	fd, err := openPlain(fileName, c.KeyProvider)
+	if err != nil {
+		return 0
+	}
+	defer fd.Close()
+
+	header := c.FileHeader
+	if c.FrameMode {
+		header = appendFrame(nil, header)
+	}
+	buf := make([]byte, len(header))
+	if _, err = io.ReadFull(fd, buf); err != nil || !bytes.Equal(buf, header) {
+		return 0
+	}
+	if c.FrameMode {
+		return 1
+	}
+	return int64(bytes.Count(buf, lineSep))
+}
+
+//写入文件头(已持有锁)
+//fileName	输入新文件名
+//prevName	输入上一文件名
+//size    	输出文件头尺寸
+//lines   	输出文件头行数
+func (mw *MutexWrite) writeHeader(fileName, prevName string) (size, lines int64) {
+	header := mw.cfger.GetFileHeader(&HeaderInfo{
+		FileName: fileName,
+		PrevName: prevName,
+		OpenTime: time.Now(),
+		Chain:    mw.chain.prevHex(),
+	})
+	if len(header) == 0 {
+		os.Remove(fileName + HeadSuffix)
+		return 0, 0
+	}
+	header = mw.frameBytes(header)
+
+	n, err := mw.writeFile(header)
+	mw.stats.add(header[:n], 0)
+	mw.chain.add(header[:n])
+	mw.index.add(header[:n])
+	if err != nil {
+		mw.log(LevelError, "write header", fileName, err)
+	}
+	if lines = int64(bytes.Count(header[:n], lineSep)); mw.cfger.IsFrameMode() {
+		lines = 1
+	}
+	if !mw.cfger.IsHeaderLines() {
+		mw.index.setHead(lines)
+		if err = replaceFile(fileName+HeadSuffix, []byte(strconv.FormatInt(lines, 10))); err != nil {
+			mw.log(LevelError, "write header lines", fileName, err)
+		}
+	}
+	return int64(n), lines
+}
+
+//读取写入文件头时记录的文件头行数
+//fileName	输入数据文件名
+func loadHeadLines(fileName string) (int64, bool) {
+	data, err := os.ReadFile(fileName + HeadSuffix)
+	if err != nil {
+		return 0, false
+	}
+	lines, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	return lines, err == nil && lines >= 0
+}
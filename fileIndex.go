@@ -0,0 +1,208 @@
+// fileIndex
+package fwrite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+const (
+	IndexSuffix = ".idx" //索引文件后缀
+
+	indexMagic = "FWINDEX1" //索引文件标识
+	indexHead  = 24         //索引文件头尺寸: 标识8字节 + 间隔行数8字节 + 文件头行数8字节
+	indexEntry = 8          //索引项尺寸: 偏移8字节
+)
+
+//稀疏行偏移索引, 每Every行记录一次偏移, 第i项为前(i+1)*Every行结束偏移,
+//索引按物理行计算, 包含文件头行, 索引文件头记录未计入行数的文件头行数
+type fileIndex struct {
+	fd     *os.File //索引文件
+	frames bool     //是否按帧索引
+	every  int64    //索引间隔行数
+	lines  int64    //已写物理行数
+	size   int64    //已写字节数
+	buf    [indexEntry]byte
+}
+
+//设置索引间隔行数
+func (c *FileConfig) SetIndexEvery(indexEvery int64) {
+	c.IndexEvery = indexEvery
+}
+
+//获取索引间隔行数, 0为不建索引
+func (c *FileConfig) GetIndexEvery() int64 {
+	return c.IndexEvery
+}
+
+//添加写入数据, 帧模式时每次写入为一帧
+func (x *fileIndex) add(b []byte) {
+	if x.fd == nil {
+		return
+	}
+	if x.frames {
+		x.addFrame(len(b))
+		return
+	}
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			x.size += int64(len(b))
+			return
+		}
+		x.addFrame(i + 1)
+		b = b[i+1:]
+	}
+}
+
+//添加一行或一帧
+func (x *fileIndex) addFrame(size int) {
+	x.size += int64(size)
+	x.lines++
+	if x.lines%x.every == 0 {
+		binary.BigEndian.PutUint64(x.buf[:], uint64(x.size))
+		x.fd.Write(x.buf[:])
+	}
+}
+
+//记录未计入行数的文件头行数
+func (x *fileIndex) setHead(lines int64) {
+	if x.fd != nil {
+		binary.BigEndian.PutUint64(x.buf[:], uint64(lines))
+		x.fd.WriteAt(x.buf[:], 16)
+	}
+}
+
+//封存索引文件
+func (x *fileIndex) close() {
+	if x.fd != nil {
+		x.fd.Close()
+		x.fd = nil
+	}
+}
+
+//打开索引文件(已持有锁), 重新打开的非空文件从最后索引项继续
+//fileName	输入数据文件名
+//fileSize	输入数据文件尺寸
+func (mw *MutexWrite) openIndex(fileName string, fileSize int64) {
+	mw.index.close()
+	every := mw.cfger.GetIndexEvery()
+	if every <= 0 {
+		return
+	}
+
+	x := &mw.index
+	x.every, x.lines, x.size = every, 0, 0
+	x.frames = mw.cfger.IsFrameMode()
+	fd, err := os.OpenFile(fileName+IndexSuffix, os.O_RDWR|os.O_CREATE, 0660)
+	if err != nil {
+		mw.log(LevelError, "open index", fileName, err)
+		return
+	}
+
+	//校验已有索引, 不一致则重建
+	var head [indexHead]byte
+	entries := int64(0)
+	if stat, e := fd.Stat(); e == nil && fileSize > 0 && stat.Size() >= indexHead {
+		if _, e = fd.ReadAt(head[:], 0); e == nil &&
+			string(head[:8]) == indexMagic &&
+			int64(binary.BigEndian.Uint64(head[8:16])) == every {
+			entries = (stat.Size() - indexHead) / indexEntry
+		}
+	}
+	for entries > 0 {
+		if _, e := fd.ReadAt(x.buf[:], indexHead+(entries-1)*indexEntry); e == nil {
+			if x.size = int64(binary.BigEndian.Uint64(x.buf[:])); x.size <= fileSize {
+				break
+			}
+		}
+		entries--
+	}
+	if entries == 0 {
+		copy(head[:8], indexMagic)
+		binary.BigEndian.PutUint64(head[8:16], uint64(every))
+		fd.WriteAt(head[:], 0)
+		x.size = 0
+	}
+	x.lines = entries * every
+	fd.Truncate(indexHead + entries*indexEntry)
+	fd.Seek(0, io.SeekEnd)
+	x.fd = fd
+
+	//补齐最后索引项之后的数据
+	if x.size < fileSize {
+		src, err := openPlain(fileName, mw.cfger.GetKeyProvider())
+		if err != nil {
+			return
+		}
+		defer src.Close()
+		if err = skipPlain(src, x.size); err != nil {
+			return
+		}
+		rest := io.LimitReader(src, fileSize-x.size)
+		if x.frames {
+			walkFrames(rest, x.addFrame)
+			return
+		}
+		buf := make([]byte, 32768) // 32k
+		for {
+			n, err := rest.Read(buf)
+			x.add(buf[:n])
+			if err != nil {
+				break
+			}
+		}
+	}
+}
+
+//读取索引记录的未计入行数的文件头行数
+//fileName	输入数据文件名
+func indexHeadLines(fileName string) (int64, bool) {
+	fd, err := os.Open(fileName + IndexSuffix)
+	if err != nil {
+		return 0, false
+	}
+	defer fd.Close()
+
+	var head [indexHead]byte
+	if _, err = fd.ReadAt(head[:], 0); err != nil || string(head[:8]) != indexMagic {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(head[16:])), true
+}
+
+//查找索引, 返回物理行号之前最近的索引项
+//fileName	输入数据文件名
+//line    	输入物理行号
+func lookupIndex(fileName string, line int64) (mark FileMark, ok bool) {
+	fd, err := os.Open(fileName + IndexSuffix)
+	if err != nil {
+		return mark, false
+	}
+	defer fd.Close()
+
+	var head [indexHead]byte
+	if _, err = fd.ReadAt(head[:], 0); err != nil || string(head[:8]) != indexMagic {
+		return mark, false
+	}
+	every := int64(binary.BigEndian.Uint64(head[8:16]))
+	stat, err := fd.Stat()
+	if err != nil || every <= 0 {
+		return mark, false
+	}
+
+	i := (line-1)/every - 1
+	if entries := (stat.Size() - indexHead) / indexEntry; i >= entries {
+		i = entries - 1
+	}
+	if i < 0 {
+		return mark, false
+	}
+	var buf [indexEntry]byte
+	if _, err = fd.ReadAt(buf[:], indexHead+i*indexEntry); err != nil {
+		return mark, false
+	}
+	return FileMark{Line: (i + 1) * every, Offset: int64(binary.BigEndian.Uint64(buf[:]))}, true
+}
@@ -0,0 +1,69 @@
+// fileLines
+package fwrite
+
+import (
+	"bytes"
+)
+
+//内嵌换行符处理方式
+const (
+	NewlineKeep   = iota //保留内嵌换行符
+	NewlineEscape        //转义内嵌换行符为`\n`, 反斜杠先转义为`\\`
+	NewlineReject        //拒绝含内嵌换行符的记录
+)
+
+var (
+	lineSep       = []byte{'\n'}
+	lineEscape    = []byte{'\\', 'n'}
+	lineBackslash = []byte{'\\'}
+	lineEscapeBs  = []byte{'\\', '\\'}
+)
+
+//是否每条记录都以换行结尾, 补齐换行、行计数或分配全局序号时成立
+func (c *FileConfig) IsLineEnded() bool {
+	return c.LineAppend || c.LineCount || c.SeqMode
+}
+
+//整理记录数据
+//in    	输入记录数据
+//out   	输出整理后数据
+//lines 	输出记录行数
+//err   	输出错误信息
+func (c *FileConfig) lineRecord(in []byte) (out []byte, lines int64, err error) {
+	body, ended := in, false
+	if l := len(in); l > 0 && in[l-1] == '\n' {
+		body, ended = in[:l-1], true
+	}
+
+	out = in
+	switch c.LineNewline {
+	case NewlineEscape:
+		//先转义反斜杠, 使原有的`\n`与转义后的换行符可以区分
+		if bytes.IndexByte(body, '\n') >= 0 || bytes.IndexByte(body, '\\') >= 0 {
+			out = bytes.Replace(body, lineBackslash, lineEscapeBs, -1)
+			out = bytes.Replace(out, lineSep, lineEscape, -1)
+			if ended {
+				out = append(out, '\n')
+			}
+		}
+	default:
+		//全局序号按行定位记录, 每条记录必须恰为一行
+		if (c.LineNewline == NewlineReject || c.SeqMode) && bytes.IndexByte(body, '\n') >= 0 {
+			return nil, 0, ErrNewline
+		}
+	}
+
+	//行计数或分配全局序号时每条记录至少占一行, 未以换行结尾的记录同样补齐换行
+	if c.IsLineEnded() && !ended {
+		if len(out) == len(in) {
+			//复制数据, 不修改调用者缓存
+			out = append(make([]byte, 0, len(in)+1), in...)
+		}
+		out = append(out, '\n')
+	}
+
+	if !c.LineCount {
+		return out, 1, nil
+	}
+	return out, int64(bytes.Count(out, lineSep)), nil
+}
@@ -5,81 +5,130 @@ import (
 	"os"
 	"strings"
 	"sync"
-	"time"
+	"sync/atomic"
 )
 
-const (
-	traceLock = true
-)
-
-type fileLock struct {
-	files map[string]*os.File
+//打开文件登记表, 记录写入器组内各文件名的持有者
+//	同一登记表内持有的文件不会被切换和清理重命名或删除,
+//	不同写入器组使用各自的登记表互不影响
+type FileRegistry struct {
+	files map[string][]*os.File
 	mu    sync.Mutex
+	trace int32 //是否输出跟踪信息
+}
+
+//创建打开文件登记表
+func NewFileRegistry() *FileRegistry {
+	return &FileRegistry{files: make(map[string][]*os.File)}
+}
+
+//获取进程默认打开文件登记表, 未设置登记表的写入器共用
+func DefaultRegistry() *FileRegistry {
+	return defaultRegistry
+}
+
+//设置写入器所属的打开文件登记表, 须在Init前设置
+//registry	输入登记表, 为空时使用进程默认登记表
+func (w *FileWrite) SetRegistry(registry *FileRegistry) {
+	w.mu.Lock()
+	w.cfg.Registry = registry
+	w.mu.Unlock()
+}
+
+//设置是否输出跟踪信息, 可在运行时切换
+func (f *FileRegistry) SetTrace(trace bool) {
+	if trace {
+		atomic.StoreInt32(&f.trace, 1)
+	} else {
+		atomic.StoreInt32(&f.trace, 0)
+	}
+}
+
+//输出跟踪信息
+//op    	输入操作
+//name  	输入文件名
+//result	输入操作结果
+func (f *FileRegistry) traceOp(op, name, result string) {
+	if atomic.LoadInt32(&f.trace) == 1 {
+		logEvent(DefaultLogger(), LevelTrace, "$FileRegistry", op, name, nil,
+			Field{"result", result})
+	}
 }
 
-func (f *fileLock) DoLock(file *os.File) bool {
+//登记文件名统一使用'/'分隔
+func registryKey(name string) string {
+	return strings.Replace(name, `\`, `/`, -1)
+}
+
+//登记持有文件
+//file	输入打开的文件
+//bool	输出是否新登记, 已登记返回false
+func (f *FileRegistry) Acquire(file *os.File) bool {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	if _, ok := f.files[file.Name()]; !ok {
-		f.files[file.Name()] = file
-		if traceLock {
-			printf("<TRACE>[%s] $fileLock.DoLock \"%s\" Success.\n\n",
-				time.Now().Format(logFormat), file.Name())
-		}
-		return true
-	} else {
-		if traceLock {
-			printf("<TRACE>[%s] $fileLock.DoLock \"%s\" IsExist.\n\n",
-				time.Now().Format(logFormat), file.Name())
+	name := registryKey(file.Name())
+	for _, holder := range f.files[name] {
+		if holder == file {
+			f.traceOp("acquire", name, "IsExist")
+			return false
 		}
-		return false
 	}
+	f.files[name] = append(f.files[name], file)
+	f.traceOp("acquire", name, "Success")
+	return true
 }
 
-func (f *fileLock) Unlock(file *os.File) bool {
+//释放持有文件
+//file	输入登记的文件
+//bool	输出是否释放, 未登记返回false
+func (f *FileRegistry) Release(file *os.File) bool {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	if _, ok := f.files[file.Name()]; ok {
-		delete(f.files, file.Name())
-		if traceLock {
-			printf("<TRACE>[%s] $fileLock.Unlock \"%s\" Success.\n\n",
-				time.Now().Format(logFormat), file.Name())
+	name := registryKey(file.Name())
+	holders := f.files[name]
+	for i, holder := range holders {
+		if holder == file {
+			if len(holders) == 1 {
+				delete(f.files, name)
+			} else {
+				f.files[name] = append(holders[:i:i], holders[i+1:]...)
+			}
+			f.traceOp("release", name, "Success")
+			return true
 		}
-		return ok
-	} else {
-		if traceLock {
-			printf("<TRACE>[%s] $fileLock.Unlock \"%s\" Not Exist.\n\n",
-				time.Now().Format(logFormat), file.Name())
-		}
-		return ok
 	}
+	f.traceOp("release", name, "Not Exist")
+	return false
 }
 
-func (f *fileLock) Exists(name string) bool {
+//获取文件名的全部持有者
+//name	输入文件名
+func (f *FileRegistry) Holders(name string) []*os.File {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-	name = strings.Replace(name, `\`, `/`, -1)
+	holders := f.files[registryKey(name)]
+	if len(holders) == 0 {
+		return nil
+	}
+	return append([]*os.File(nil), holders...)
+}
 
-	ok := func() bool {
-		f.mu.Lock()
-		defer f.mu.Unlock()
+//文件名是否被持有
+//name	输入文件名
+func (f *FileRegistry) Exists(name string) bool {
+	name = registryKey(name)
 
-		_, ok := f.files[name]
-		return ok
-	}()
+	f.mu.Lock()
+	_, ok := f.files[name]
+	f.mu.Unlock()
 
 	if ok {
-		if traceLock {
-			printf("<TRACE>[%s] $fileLock.Exists \"%s\" Is Locked.\n\n",
-				time.Now().Format(logFormat), name)
-		}
+		f.traceOp("exists", name, "Is Locked")
 	} else {
-		if traceLock {
-			printf("<TRACE>[%s] $fileLock.Exists \"%s\" Not Exist.\n\n",
-				time.Now().Format(logFormat), name)
-		}
+		f.traceOp("exists", name, "Not Exist")
 	}
-
 	return ok
 }
@@ -0,0 +1,357 @@
+// fileManifest
+package fwrite
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	flock "github.com/yireyun/go-flock"
+)
+
+const (
+	ManifestName = "fwrite.manifest" //校验清单文件名, 每目录一个
+)
+
+var (
+	ErrManifestMismatch = errors.New("manifest mismatch") //文件与校验清单不符
+
+	manifestMu sync.Mutex //校验清单进程内互斥, 进程间以锁文件互斥
+)
+
+//校验清单记录, 文件封存或压缩时追加
+type ManifestEntry struct {
+	Name      string    `json:"name"`             //文件名, 不含目录
+	Size      int64     `json:"size"`             //文件尺寸
+	Lines     int64     `json:"lines"`            //行数或帧数
+	Sha256    string    `json:"sha256"`           //文件SHA-256
+	FirstTime time.Time `json:"firstTime"`        //首条记录时间
+	LastTime  time.Time `json:"lastTime"`         //末条记录时间
+	Sealed    time.Time `json:"sealed"`           //封存时间
+	Source    string    `json:"source,omitempty"` //压缩前文件名
+}
+
+//是否封存时写入校验清单
+func (c *FileConfig) IsManifest() bool {
+	return c.Manifest
+}
+
+//设置是否封存时写入校验清单, 启用时同时启用文件统计
+func (w *FileWrite) SetManifest(manifest bool) {
+	w.mu.Lock()
+	w.cfg.Manifest = manifest
+	w.mu.Unlock()
+}
+
+//获取文件所在目录的校验清单文件名
+//fileName	输入文件名
+func manifestPath(fileName string) string {
+	return filepath.Join(filepath.Dir(fileName), ManifestName)
+}
+
+//计算文件SHA-256和行数
+//rd   	输入文件内容
+//frame	输入是否二进制帧模式
+func sumFile(rd io.Reader, frame bool) (sum []byte, lines int64, err error) {
+	h := sha256.New()
+	if frame {
+		lines, _, _ = walkFrames(io.TeeReader(rd, h), nil)
+		//不完整帧之后的内容只计入摘要
+		if _, err = io.Copy(h, rd); err != nil {
+			return nil, 0, err
+		}
+		return h.Sum(nil), lines, nil
+	}
+
+	buf := make([]byte, 32768) // 32k
+	for {
+		n, e := rd.Read(buf)
+		h.Write(buf[:n])
+		lines += int64(bytes.Count(buf[:n], lineSep))
+		if e == io.EOF {
+			return h.Sum(nil), lines, nil
+		}
+		if e != nil {
+			return nil, 0, e
+		}
+	}
+}
+
+//生成封存文件校验清单记录, 加密文件摘要为密文摘要, 行数为明文行数,
+//记录时间取文件修改时间
+//fileName	输入文件名
+//frame   	输入是否二进制帧模式
+//keys    	输入解密密钥提供者
+func newManifestEntry(fileName string, frame bool, keys KeyProvider) (*ManifestEntry, error) {
+	fd, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	stat, err := fd.Stat()
+	if err != nil {
+		return nil, err
+	}
+	sum, lines, err := sumFile(fd, frame)
+	if err != nil {
+		return nil, err
+	}
+	if isCryptFile(fd) {
+		rc, err := openPlain(fileName, keys)
+		if err != nil {
+			return nil, err
+		}
+		_, lines, err = sumFile(rc, frame)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &ManifestEntry{
+		Name:      filepath.Base(fileName),
+		Size:      stat.Size(),
+		Lines:     lines,
+		Sha256:    hex.EncodeToString(sum),
+		FirstTime: stat.ModTime(),
+		LastTime:  stat.ModTime(),
+		Sealed:    time.Now(),
+	}, nil
+}
+
+//加锁校验清单, 进程内互斥后锁定锁文件, 避免与其他进程的重写交错
+//path  	输入校验清单文件名
+//unlock	输出解锁函数
+func lockManifest(path string) (unlock func(), err error) {
+	manifestMu.Lock()
+	fl := flock.NewFlock(path + LockSuffix)
+	if err = fl.Lock(); err != nil {
+		manifestMu.Unlock()
+		return nil, newLockError(path+LockSuffix, err)
+	}
+	return func() {
+		fl.Unlock()
+		manifestMu.Unlock()
+	}, nil
+}
+
+//追加校验清单记录
+//fileName	输入文件名
+//entry   	输入校验清单记录
+func appendManifest(fileName string, entry *ManifestEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	unlock, err := lockManifest(manifestPath(fileName))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	fd, err := os.OpenFile(manifestPath(fileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0660)
+	if err != nil {
+		return err
+	}
+	if _, err = fd.Write(data); err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
+
+//封存文件读取文件写入校验清单
+//fileName	输入封存文件名
+//frame   	输入是否二进制帧模式
+//keys    	输入解密密钥提供者
+func sealManifest(fileName string, frame bool, keys KeyProvider) {
+	entry, err := newManifestEntry(fileName, frame, keys)
+	if err == nil {
+		err = appendManifest(fileName, entry)
+	}
+	if err != nil {
+		logEvent(DefaultLogger(), LevelError, "", "manifest", fileName, err)
+	}
+}
+
+//封存当前文件写入校验清单(已持有锁), 未启用时忽略,
+//摘要、尺寸和行数取自写入时的文件统计, 不重新读取文件
+//fileName	输入封存文件名
+func (mw *MutexWrite) sealManifest(fileName string) {
+	if !mw.cfger.IsManifest() || !mw.stats.on {
+		return
+	}
+	entry := &ManifestEntry{
+		Name:      filepath.Base(fileName),
+		Size:      mw.stats.size,
+		Lines:     mw.stats.lines,
+		Sha256:    hex.EncodeToString(mw.stats.file.Sum(nil)),
+		FirstTime: mw.stats.first,
+		LastTime:  mw.stats.last,
+		Sealed:    time.Now(),
+	}
+	if mw.stats.records == 0 {
+		entry.FirstTime, entry.LastTime = entry.Sealed, entry.Sealed
+	}
+	if err := appendManifest(fileName, entry); err != nil {
+		mw.log(LevelError, "manifest", fileName, err)
+	}
+}
+
+//后台处理未统计的封存文件: 读取文件写入校验清单后再压缩
+//cfger   	输入配置接口
+//name    	输入写入器名称
+//fileName	输入封存文件名
+func sealReport(cfger MutexConfiger, name, fileName string) {
+	if cfger.IsManifest() {
+		sealManifest(fileName, cfger.IsFrameMode(), cfger.GetKeyProvider())
+	}
+	if cfger.IsFileZip() {
+		zipReport(cfger, name, fileName)
+	}
+}
+
+//读取目录校验清单
+//dir	输入目录
+func LoadManifest(dir string) ([]*ManifestEntry, error) {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	return loadManifest(filepath.Join(dir, ManifestName))
+}
+
+func loadManifest(path string) ([]*ManifestEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	entries := make([]*ManifestEntry, 0, 64)
+	scan := bufio.NewScanner(bytes.NewReader(data))
+	for scan.Scan() {
+		entry := new(ManifestEntry)
+		if json.Unmarshal(scan.Bytes(), entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scan.Err()
+}
+
+//重写校验清单, 先写临时文件再重命名(已加锁校验清单)
+func saveManifest(path string, entries []*ManifestEntry) error {
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		data, _ := json.Marshal(entry)
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	return replaceFile(path, buf.Bytes())
+}
+
+//删除校验清单中已删除文件的记录
+//fileNames	输入已删除的文件名, 位于同一目录
+func removeManifest(fileNames []string) error {
+	if len(fileNames) == 0 {
+		return nil
+	}
+	path := manifestPath(fileNames[0])
+	removed := make(map[string]bool, len(fileNames))
+	for _, fileName := range fileNames {
+		removed[filepath.Base(fileName)] = true
+	}
+
+	unlock, err := lockManifest(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	entries, err := loadManifest(path)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+	kept := entries[:0]
+	for _, entry := range entries {
+		if !removed[entry.Name] {
+			kept = append(kept, entry)
+		}
+	}
+	if len(kept) == len(entries) {
+		return nil
+	}
+	return saveManifest(path, kept)
+}
+
+//压缩文件替换校验清单中原文件的记录, 原文件无记录时忽略
+//fileName	输入压缩前文件名
+func zipManifest(fileName string) error {
+	path, name := manifestPath(fileName), filepath.Base(fileName)
+
+	//加锁前计算压缩文件摘要
+	fd, err := os.Open(fileName + zipFileSuffix)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	size, err := io.Copy(h, fd)
+	fd.Close()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockManifest(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	entries, err := loadManifest(path)
+	if err != nil {
+		return err
+	}
+	index := -1
+	for i, entry := range entries {
+		if entry.Name == name {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil
+	}
+
+	entry := *entries[index]
+	entry.Name, entry.Source = name+zipFileSuffix, name
+	entry.Size, entry.Sha256 = size, hex.EncodeToString(h.Sum(nil))
+	entry.Sealed = time.Now()
+	entries = append(append(entries[:index:index], entries[index+1:]...), &entry)
+	return saveManifest(path, entries)
+}
+
+//校验文件与清单记录是否一致
+//dir	输入文件所在目录
+func (e *ManifestEntry) Check(dir string) error {
+	fd, err := os.Open(filepath.Join(dir, e.Name))
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, fd)
+	if err != nil {
+		return err
+	}
+	if size != e.Size || !strings.EqualFold(hex.EncodeToString(h.Sum(nil)), e.Sha256) {
+		return errorf("%s %w: size %d, sha256 %x", e.Name, ErrManifestMismatch, size, h.Sum(nil))
+	}
+	return nil
+}
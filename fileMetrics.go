@@ -0,0 +1,292 @@
+// fileMetrics
+package fwrite
+
+import (
+	"bufio"
+	"expvar"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//延迟直方图桶上限(秒)
+var latencyBuckets = []float64{
+	0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1,
+}
+
+//旋转原因, 与rotations计数顺序一致
+var rotateReasons = []string{RotateLines, RotateSize, RotateDay, RotateBatch, RotateManual}
+
+//延迟直方图
+type Histogram struct {
+	Buckets []float64 //桶上限(秒)
+	Counts  []uint64  //小于等于各桶上限的累计计数
+	Count   uint64    //总计数
+	Sum     float64   //总延迟(秒)
+}
+
+//写入器运行统计快照
+type WriterStats struct {
+	Name         string            //写入器名称
+	FileName     string            //当前文件名
+	CurSize      int64             //当前文件尺寸
+	CurLines     int64             //当前文件行数
+	Writes       uint64            //写入调用数
+	WriteErrors  uint64            //写入失败数
+	Records      uint64            //写入记录数
+	Bytes        uint64            //写入字节数
+	InFlight     int64             //进行中的写入调用数, 含等待锁的调用, 非排队深度
+	WriteLatency Histogram         //写入延迟
+	SyncLatency  Histogram         //刷盘延迟
+	Rotations    map[string]uint64 //按原因统计的旋转数
+	Compressions uint64            //压缩完成数
+	Deleted      uint64            //保留期清理删除文件数
+	Errors       ErrorStats        //错误计数
+}
+
+//延迟直方图计数
+type histogram struct {
+	counts [12]uint64 //各桶计数, 最后一个为超出最大桶
+	count  uint64     //总计数
+	sum    int64      //总延迟(纳秒)
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBuckets) && d.Seconds() > latencyBuckets[i] {
+		i++
+	}
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+func (h *histogram) snapshot() Histogram {
+	s := Histogram{Buckets: latencyBuckets, Counts: make([]uint64, len(latencyBuckets))}
+	var total uint64
+	for i := range latencyBuckets {
+		total += atomic.LoadUint64(&h.counts[i])
+		s.Counts[i] = total
+	}
+	s.Count = atomic.LoadUint64(&h.count)
+	s.Sum = time.Duration(atomic.LoadInt64(&h.sum)).Seconds()
+	return s
+}
+
+//写入器运行计数
+type fileMetrics struct {
+	writes       uint64
+	writeErrors  uint64
+	records      uint64
+	bytes        uint64
+	inflight     int64
+	rotations    [5]uint64 //与rotateReasons对应
+	compressions uint64
+	deleted      uint64
+	write        histogram
+	sync         histogram
+}
+
+//开始写入, 返回开始时间
+func (m *fileMetrics) writeStart() time.Time {
+	atomic.AddInt64(&m.inflight, 1)
+	return time.Now()
+}
+
+//结束写入
+//start  	输入开始时间
+//records	输入记录数
+//bytes  	输入字节数
+//err    	输入写入错误
+func (m *fileMetrics) writeDone(start time.Time, records, bytes int, err error) {
+	m.write.observe(time.Since(start))
+	atomic.AddInt64(&m.inflight, -1)
+	atomic.AddUint64(&m.writes, 1)
+	if err != nil {
+		atomic.AddUint64(&m.writeErrors, 1)
+		return
+	}
+	atomic.AddUint64(&m.records, uint64(records))
+	atomic.AddUint64(&m.bytes, uint64(bytes))
+}
+
+//按事件计数旋转、压缩和删除
+//ev	输入事件
+func (m *fileMetrics) countEvent(ev *Event) {
+	switch ev.Type {
+	case EventRotate:
+		for i, reason := range rotateReasons {
+			if reason == ev.Reason {
+				atomic.AddUint64(&m.rotations[i], 1)
+			}
+		}
+	case EventCompress:
+		atomic.AddUint64(&m.compressions, 1)
+	case EventClean:
+		atomic.AddUint64(&m.deleted, 1)
+	}
+}
+
+//读取运行统计快照
+func (w *FileWrite) Stats() WriterStats {
+	m := &w.cfg.metrics
+	st := WriterStats{
+		Name:         w._Name_,
+		Writes:       atomic.LoadUint64(&m.writes),
+		WriteErrors:  atomic.LoadUint64(&m.writeErrors),
+		Records:      atomic.LoadUint64(&m.records),
+		Bytes:        atomic.LoadUint64(&m.bytes),
+		InFlight:     atomic.LoadInt64(&m.inflight),
+		WriteLatency: m.write.snapshot(),
+		SyncLatency:  m.sync.snapshot(),
+		Rotations:    make(map[string]uint64, len(rotateReasons)),
+		Compressions: atomic.LoadUint64(&m.compressions),
+		Deleted:      atomic.LoadUint64(&m.deleted),
+		Errors:       w.Errors(),
+	}
+	for i, reason := range rotateReasons {
+		st.Rotations[reason] = atomic.LoadUint64(&m.rotations[i])
+	}
+
+	w.mu.Lock()
+	st.FileName, st.CurSize, st.CurLines = w.cfg.FileName, w.cfg.CurSize, w.cfg.CurLines
+	w.mu.Unlock()
+	return st
+}
+
+//按Prometheus文本格式输出写入器运行统计
+//out    	输入输出
+//writers	输入写入器
+func WriteMetrics(out io.Writer, writers ...*FileWrite) error {
+	stats := make([]WriterStats, len(writers))
+	for i, w := range writers {
+		stats[i] = w.Stats()
+	}
+
+	b := bufio.NewWriter(out)
+	metric := func(name, kind, help string, value func(st *WriterStats)) {
+		b.WriteString("# HELP " + name + " " + help + "\n")
+		b.WriteString("# TYPE " + name + " " + kind + "\n")
+		for i := range stats {
+			value(&stats[i])
+		}
+	}
+	sample := func(name, labels string, value float64) {
+		b.WriteString(name + "{" + labels + "} " + strconv.FormatFloat(value, 'g', -1, 64) + "\n")
+	}
+	writer := func(st *WriterStats) string {
+		return "writer=" + strconv.Quote(st.Name)
+	}
+	counter := func(name, help string, value func(st *WriterStats) uint64) {
+		metric(name, "counter", help, func(st *WriterStats) {
+			sample(name, writer(st), float64(value(st)))
+		})
+	}
+	gauge := func(name, help string, value func(st *WriterStats) int64) {
+		metric(name, "gauge", help, func(st *WriterStats) {
+			sample(name, writer(st), float64(value(st)))
+		})
+	}
+	histogram := func(name, help string, value func(st *WriterStats) Histogram) {
+		metric(name, "histogram", help, func(st *WriterStats) {
+			h := value(st)
+			for i, le := range h.Buckets {
+				sample(name+"_bucket", writer(st)+",le=\""+
+					strconv.FormatFloat(le, 'g', -1, 64)+"\"", float64(h.Counts[i]))
+			}
+			sample(name+"_bucket", writer(st)+",le=\"+Inf\"", float64(h.Count))
+			sample(name+"_sum", writer(st), h.Sum)
+			sample(name+"_count", writer(st), float64(h.Count))
+		})
+	}
+
+	counter("fwrite_writes_total", "Write calls.",
+		func(st *WriterStats) uint64 { return st.Writes })
+	counter("fwrite_write_errors_total", "Failed write calls.",
+		func(st *WriterStats) uint64 { return st.WriteErrors })
+	counter("fwrite_records_written_total", "Records written.",
+		func(st *WriterStats) uint64 { return st.Records })
+	counter("fwrite_bytes_written_total", "Bytes written.",
+		func(st *WriterStats) uint64 { return st.Bytes })
+	histogram("fwrite_write_duration_seconds", "Write call latency.",
+		func(st *WriterStats) Histogram { return st.WriteLatency })
+	histogram("fwrite_sync_duration_seconds", "Flush fsync latency.",
+		func(st *WriterStats) Histogram { return st.SyncLatency })
+	metric("fwrite_rotations_total", "counter", "File rotations by reason.",
+		func(st *WriterStats) {
+			for _, reason := range rotateReasons {
+				sample("fwrite_rotations_total", writer(st)+",reason=\""+reason+"\"",
+					float64(st.Rotations[reason]))
+			}
+		})
+	metric("fwrite_errors_total", "counter", "Internal operation failures by type.",
+		func(st *WriterStats) {
+			for _, e := range []struct {
+				kind  string
+				count uint64
+			}{
+				{"rotate", st.Errors.Rotate}, {"rename", st.Errors.Rename},
+				{"compress", st.Errors.Compress}, {"lock", st.Errors.Lock},
+				{"clean", st.Errors.Clean}, {"other", st.Errors.Other},
+			} {
+				sample("fwrite_errors_total", writer(st)+",type=\""+e.kind+"\"",
+					float64(e.count))
+			}
+		})
+	counter("fwrite_compressions_total", "Sealed files compressed.",
+		func(st *WriterStats) uint64 { return st.Compressions })
+	counter("fwrite_files_deleted_total", "Files deleted by retention.",
+		func(st *WriterStats) uint64 { return st.Deleted })
+	gauge("fwrite_file_size_bytes", "Current file size.",
+		func(st *WriterStats) int64 { return st.CurSize })
+	gauge("fwrite_file_lines", "Current file lines.",
+		func(st *WriterStats) int64 { return st.CurLines })
+	gauge("fwrite_writes_in_flight", "Write calls in progress, including those waiting for the lock.",
+		func(st *WriterStats) int64 { return st.InFlight })
+	return b.Flush()
+}
+
+//创建Prometheus文本格式的运行统计处理器
+//writers	输入写入器
+func MetricsHandler(writers ...*FileWrite) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteMetrics(rw, writers...)
+	})
+}
+
+//已发布的expvar变量, 按变量名保存当前输出的写入器
+var expvarWriters = struct {
+	sync.Mutex
+	m map[string]*[]*FileWrite
+}{m: make(map[string]*[]*FileWrite)}
+
+//以expvar发布写入器运行统计, 按写入器名称输出
+//	同名重复发布时改为输出新的写入器, expvar变量只发布一次
+//name   	输入expvar变量名
+//writers	输入写入器
+func PublishExpvar(name string, writers ...*FileWrite) {
+	expvarWriters.Lock()
+	defer expvarWriters.Unlock()
+
+	if cur, ok := expvarWriters.m[name]; ok {
+		*cur = writers
+		return
+	}
+	cur := &writers
+	expvarWriters.m[name] = cur
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		expvarWriters.Lock()
+		writers := *cur
+		expvarWriters.Unlock()
+
+		stats := make(map[string]WriterStats, len(writers))
+		for _, w := range writers {
+			stats[w._Name_] = w.Stats()
+		}
+		return stats
+	}))
+}
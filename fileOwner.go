@@ -0,0 +1,271 @@
+// fileOwner
+package fwrite
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	flock "github.com/yireyun/go-flock"
+)
+
+var (
+	ErrLockHeld    = errors.New("lock is held by another process") //锁已被其它进程持有
+	ErrLockTimeout = errors.New("lock wait timeout")               //等待锁超时
+)
+
+//锁文件中记录的持有者信息
+type lockOwner struct {
+	Pid     int       `json:"pid"`     //持有进程号
+	Host    string    `json:"host"`    //持有主机名
+	Boot    time.Time `json:"boot"`    //持有进程启动时间, 未知为零值
+	Start   time.Time `json:"start"`   //持有开始时间
+	Name    string    `json:"name"`    //持有写入器名称
+	Refresh time.Time `json:"refresh"` //最后刷新时间
+}
+
+//创建当前进程的锁文件持有者
+//name	输入写入器名称
+func newLockOwner(name string) lockOwner {
+	owner := lockOwner{Pid: os.Getpid(), Start: time.Now(), Name: name}
+	owner.Host, _ = os.Hostname()
+	owner.Boot, _ = pidStart(owner.Pid)
+	return owner
+}
+
+//写入持有者信息到锁文件(已持有锁), 同时更新锁文件修改时间
+//lockName	输入锁文件名
+func (o *lockOwner) write(lockName string) error {
+	o.Refresh = time.Now()
+	data, _ := json.Marshal(o)
+	return os.WriteFile(lockName, data, 0660)
+}
+
+//判断锁文件是否失效
+//	同一主机按持有进程是否存活判断, 进程号已被新进程复用(启动时间不同)也视为失效,
+//	其它主机或无持有者信息时按修改时间判断
+//lockName	输入锁文件名
+//expired 	输入修改时间是否已过期
+func lockStale(lockName string, expired bool) bool {
+	owner := readLockOwner(lockName)
+	if owner.Pid > 0 {
+		if host, _ := os.Hostname(); owner.Host == host {
+			if !pidAlive(owner.Pid) {
+				return true
+			}
+			return pidReused(owner.Pid, owner.Boot)
+		}
+	}
+	return expired
+}
+
+//判断进程号是否已被其它进程复用, 启动时间未知时不判断
+//pid 	输入进程号
+//boot	输入记录的进程启动时间
+func pidReused(pid int, boot time.Time) bool {
+	if boot.IsZero() {
+		return false
+	}
+	start, ok := pidStart(pid)
+	if !ok {
+		return false
+	}
+	//启动时间精度受系统时钟节拍与开机时间取整影响
+	diff := start.Sub(boot)
+	return diff > time.Second || diff < -time.Second
+}
+
+//锁定错误, 包含锁文件中记录的持有者
+type LockError struct {
+	FileName string    //锁文件名
+	Pid      int       //持有进程号, 未知为0
+	Host     string    //持有主机名
+	Name     string    //持有写入器名称
+	Start    time.Time //持有开始时间
+	Err      error     //锁定错误
+}
+
+func (e *LockError) Error() string {
+	if e.Pid > 0 {
+		return sprintf("lock \"%s\" held by %s pid %d on %s since %s: %v", e.FileName,
+			e.Name, e.Pid, e.Host, e.Start.Format(logFormat), e.Err)
+	}
+	return sprintf("lock \"%s\" error: %v", e.FileName, e.Err)
+}
+
+func (e *LockError) Unwrap() error {
+	return e.Err
+}
+
+//设置独占模式, 须在Init前设置
+//	独占模式在Init时锁定活动文件的锁文件并持有到Close, 锁定失败时Init返回LockError
+//strict	输入是否独占前缀
+//wait  	输入等待锁时间, 0为不等待, 用于备机接管
+func (w *FileWrite) SetLockMode(strict bool, wait time.Duration) {
+	w.mu.Lock()
+	w.cfg.LockStrict = strict
+	w.cfg.LockWait = wait
+	w.mu.Unlock()
+}
+
+//设置锁文件持有者刷新间隔, 0为不刷新, 须在Init前设置
+func (w *FileWrite) SetLockRefresh(refresh time.Duration) {
+	w.mu.Lock()
+	w.cfg.LockRefresh = refresh
+	w.mu.Unlock()
+}
+
+//读取锁文件中记录的持有者
+//lockName	输入锁文件名
+func readLockOwner(lockName string) (owner lockOwner) {
+	if data, err := os.ReadFile(lockName); err == nil && len(data) > 0 {
+		if json.Unmarshal(data, &owner) != nil {
+			owner = lockOwner{}
+		}
+	}
+	return
+}
+
+//非阻塞加锁, 失败时在等待时间内重试
+//fl  	输入文件锁
+//wait	输入等待时间, 0为不等待
+func lockWait(fl flock.Flocker, wait time.Duration) error {
+	err := fl.NBLock()
+	if err == nil {
+		return nil
+	}
+	if wait <= 0 {
+		return errorf("%w: %v", ErrLockHeld, err)
+	}
+
+	interval := wait / 10
+	if interval > 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	deadline := time.Now().Add(wait)
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+		if fl.NBLock() == nil {
+			return nil
+		}
+	}
+	return ErrLockTimeout
+}
+
+//独占模式首次打开活动文件, 先锁定锁文件再执行文件切换(已持有w.mu)
+func (w *FileWrite) initOwner() error {
+	lockName := w.cfg.lockName()
+	fl := flock.NewFlock(lockName)
+	if err := lockWait(fl, w.cfg.LockWait); err != nil {
+		return newLockError(lockName, err)
+	}
+	w.ownerInfo = newLockOwner(w._Name_)
+	if err := w.ownerInfo.write(lockName); err != nil {
+		w.log(LevelError, "write lock owner", lockName, err)
+	}
+
+	if err := w.fileRotate(w.cfger.GetFileEof()); err != nil {
+		os.WriteFile(lockName, nil, 0660)
+		fl.Unlock()
+		return err
+	}
+	w.owner = fl
+	return nil
+}
+
+//独占模式释放锁文件, 清除持有者信息
+func (w *FileWrite) unlockOwner() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.owner == nil {
+		return
+	}
+	os.WriteFile(w.cfg.lockName(), nil, 0660)
+	if err := w.owner.Unlock(); err != nil {
+		w.fail("unlock", w.cfg.lockName(), newLockError(w.cfg.lockName(), err))
+	}
+	w.owner = nil
+}
+
+//启动锁文件持有者定时刷新, 避免长期运行的持有者被误判为失效
+func (w *FileWrite) startRefresh() {
+	if w.cfg.LockRefresh <= 0 || w.refresh != nil ||
+		(w.owner == nil && !w.cfger.IsFileLock()) {
+		return
+	}
+	w.refresh = make(chan struct{})
+	go w.lockRefresh(w.refresh, w.cfg.LockRefresh)
+}
+
+//停止锁文件持有者定时刷新
+func (w *FileWrite) stopRefresh() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.refresh != nil {
+		close(w.refresh)
+		w.refresh = nil
+	}
+}
+
+//定时刷新锁文件持有者
+//stop    	输入停止通道
+//interval	输入刷新间隔
+func (w *FileWrite) lockRefresh(stop chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			if w.owner != nil {
+				if err := w.ownerInfo.write(w.cfg.lockName()); err != nil {
+					w.fail("refresh lock", w.cfg.lockName(),
+						newLockError(w.cfg.lockName(), err))
+				}
+			}
+			w.mu.Unlock()
+			w.muwt.refreshLock()
+		}
+	}
+}
+
+//记录当前文件锁持有者(已持有mw.mutex)
+//lockName	输入锁文件名
+func (mw *MutexWrite) ownLock(lockName string) {
+	owner := newLockOwner(mw._Name_)
+	if err := owner.write(lockName); err != nil {
+		mw.log(LevelError, "write lock owner", lockName, err)
+	}
+	mw.owner = &owner
+}
+
+//释放当前文件锁, 持有时先清除持有者信息(已持有mw.mutex)
+//lockName	输入锁文件名
+func (mw *MutexWrite) unlockFile(lockName string) error {
+	if mw.owner != nil {
+		os.WriteFile(lockName, nil, 0660)
+		mw.owner = nil
+	}
+	err := mw.flock.Unlock()
+	mw.flock = nil
+	return err
+}
+
+//刷新当前文件锁持有者(Go程安全)
+func (mw *MutexWrite) refreshLock() {
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
+	if mw.owner != nil && !mw.closed {
+		lockName := mw.file.Name() + LockSuffix
+		if err := mw.owner.write(lockName); err != nil {
+			mw.fail("refresh lock", lockName, newLockError(lockName, err))
+		}
+	}
+}
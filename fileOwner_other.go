@@ -0,0 +1,68 @@
+//go:build !windows
+// +build !windows
+
+// fileOwner
+package fwrite
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+//判断进程是否存活
+//pid	输入进程号
+func pidAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+//系统时钟节拍频率, Linux用户态固定为100
+const clockTicks = 100
+
+//读取进程启动时间, 仅支持提供/proc的系统
+//pid	输入进程号
+func pidStart(pid int) (time.Time, bool) {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return time.Time{}, false
+	}
+	//进程名可能含空格, 从最后的')'之后解析, 启动时间为第22项
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return time.Time{}, false
+	}
+	fields := bytes.Fields(data[i+1:])
+	if len(fields) < 20 {
+		return time.Time{}, false
+	}
+	ticks, err := strconv.ParseInt(string(fields[19]), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	boot, ok := bootTime()
+	if !ok {
+		return time.Time{}, false
+	}
+	return boot.Add(time.Duration(ticks) * time.Second / clockTicks), true
+}
+
+//读取系统开机时间
+func bootTime() (time.Time, bool) {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}, false
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if bytes.HasPrefix(line, []byte("btime ")) {
+			sec, err := strconv.ParseInt(string(bytes.TrimSpace(line[6:])), 10, 64)
+			if err != nil {
+				return time.Time{}, false
+			}
+			return time.Unix(sec, 0), true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,38 @@
+//go:build windows
+// +build windows
+
+// fileOwner
+package fwrite
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+//判断进程是否存活, Windows打开进程句柄成功即存活
+//pid	输入进程号
+func pidAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
+
+//读取进程启动时间
+//pid	输入进程号
+func pidStart(pid int) (time.Time, bool) {
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer syscall.CloseHandle(h)
+
+	var creation, exit, kernel, user syscall.Filetime
+	if syscall.GetProcessTimes(h, &creation, &exit, &kernel, &user) != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, creation.Nanoseconds()), true
+}
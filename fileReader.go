@@ -0,0 +1,391 @@
+// fileReader
+package fwrite
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//记录位置
+type Position struct {
+	FileName string    //文件名
+	LineNo   int64     //文件行号, 从1开始, 文件头行号不大于0
+	Offset   int64     //文件偏移
+	Time     time.Time //写入时间或读取时打开时间, 用于定位重命名后的文件
+	Seq      uint64    //全局序号, 未分配为0
+}
+
+//文件记录
+type Record struct {
+	Position
+	Data []byte //记录数据, 不含结尾换行符
+}
+
+//文件读取器, 按写入顺序遍历记录器的重命名文件、压缩文件和活动文件
+type Reader struct {
+	filePrefix   string //文件名前缀
+	writeSuffix  string //正在写文件后缀
+	renameSuffix string //重命名文件后缀
+	cleanSuffix  string //清理文件后缀
+	frame        bool   //是否二进制帧模式
+	chain        bool   //是否跳过哈希链记录
+
+	files  []string      //待读文件列表
+	index  int           //当前文件序号
+	name   string        //当前文件名
+	rc     io.ReadCloser //当前文件
+	fd     *os.File      //当前文件句柄, 压缩文件为空
+	br     *bufio.Reader //当前文件缓存
+	lineNo int64         //当前文件行号
+	offset int64         //当前文件偏移
+	rec    Record        //当前记录
+	seq    uint64        //当前文件首个全局序号
+	seqNo  int64         //当前文件首个全局序号所在行号
+	err    error         //读取错误
+
+	follow  bool      //是否跟踪读取
+	live    bool      //当前文件是否活动文件
+	opened  time.Time //当前文件打开时间
+	partial []byte    //活动文件未完成的记录
+
+	keys KeyProvider //解密密钥提供者
+	eof  []byte      //静态文件结束填充, 帧模式含帧头, 生成文件结束记录时为空
+}
+
+//创建文件读取器
+//cfg	输入记录器文件配置
+func NewReader(cfg *FileConfig) *Reader {
+	if cfg == nil {
+		panic("FileConfig Is Nil")
+	}
+	r := NewFileReader(cfg.FilePrefix, cfg.WriteSuffix,
+		cfg.RenameSuffix, cfg.CleanSuffix)
+	r.frame = cfg.FrameMode
+	r.chain = cfg.ChainEvery > 0
+	r.keys = cfg.KeyProvider
+	if cfg.TrailerFunc == nil {
+		r.eof = cfg.FileEof
+	}
+	if r.frame && len(r.eof) > 0 {
+		r.eof = appendFrame(nil, r.eof)
+	}
+	return r
+}
+
+//创建文件读取器
+//filePrefix  	输入文件前缀
+//writeSuffix 	输入正在写文件后缀
+//renameSuffix	输入重命名文件后缀
+//cleanSuffix 	输入清理文件名后缀
+func NewFileReader(filePrefix, writeSuffix, renameSuffix, cleanSuffix string) *Reader {
+	return &Reader{
+		filePrefix:   trimPrefix(filePrefix),
+		writeSuffix:  trimSuffix(writeSuffix),
+		renameSuffix: trimSuffix(renameSuffix),
+		cleanSuffix:  trimSuffix(cleanSuffix),
+		index:        -1,
+	}
+}
+
+//创建记录器的文件读取器
+func (w *FileWrite) NewReader() *Reader {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return NewReader(w.cfg)
+}
+
+//设置是否二进制帧模式
+func (r *Reader) SetFrameMode(frame bool) {
+	r.frame = frame
+}
+
+//活动文件名
+func (r *Reader) activeName() string {
+	return r.filePrefix + r.writeSuffix
+}
+
+//重命名文件匹配, 如：test.log.2015-09-06.006.log.zip
+func (r *Reader) renamePattern() *regexp.Regexp {
+	suffixes := make([]string, 0, 3)
+	for _, s := range []string{r.renameSuffix, r.writeSuffix, r.cleanSuffix} {
+		if s != "" {
+			suffixes = append(suffixes, regexp.QuoteMeta(s))
+		}
+	}
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(filepath.Base(r.activeName())) +
+		`\.(\d{4}-\d{2}-\d{2})\.(\d{3,})(?:` + strings.Join(suffixes, "|") + `)(` +
+		regexp.QuoteMeta(zipFileSuffix) + `)?$`)
+}
+
+//列出记录器文件, 按日期和序号排列重命名文件(含压缩文件), 最后为活动文件
+func (r *Reader) Files() ([]string, error) {
+	if r.filePrefix == "" || r.writeSuffix == "" {
+		return nil, errorf("reader, filePrefix or writeSuffix is null")
+	}
+
+	dir := filepath.Dir(r.activeName())
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type file struct {
+		name string
+		date string
+		num  int
+		zip  bool
+	}
+	pattern := r.renamePattern()
+	files := make([]*file, 0, len(entries))
+	seen := make(map[string]*file, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		m := pattern.FindStringSubmatch(entry.Name())
+		if m == nil {
+			continue
+		}
+		num, _ := strconv.Atoi(m[2])
+		f := &file{name: filepath.Join(dir, entry.Name()), date: m[1], num: num, zip: m[3] != ""}
+		key := m[1] + "." + m[2]
+		if old, ok := seen[key]; ok {
+			//压缩未完成时同时存在原文件和压缩文件, 优先读取原文件
+			if old.zip && !f.zip {
+				*old = *f
+			}
+			continue
+		}
+		seen[key] = f
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].date != files[j].date {
+			return files[i].date < files[j].date
+		}
+		return files[i].num < files[j].num
+	})
+
+	names := make([]string, 0, len(files)+1)
+	for _, f := range files {
+		names = append(names, f.name)
+	}
+	if FileExist(r.activeName()) {
+		names = append(names, r.activeName())
+	}
+	return names, nil
+}
+
+//压缩文件读取
+type zipReadCloser struct {
+	io.ReadCloser
+	zr *zip.ReadCloser
+}
+
+func (z *zipReadCloser) Close() error {
+	z.ReadCloser.Close()
+	return z.zr.Close()
+}
+
+//打开文件, 压缩文件读取其中第一个文件, 加密文件解密读取
+//fileName	输入文件名
+//keys    	输入解密密钥提供者
+func openRecordFile(fileName string, keys KeyProvider) (io.ReadCloser, error) {
+	if filepath.Ext(fileName) != zipFileSuffix {
+		return openPlain(fileName, keys)
+	}
+
+	fd, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if isCryptFile(fd) {
+		return openCryptZip(fd, keys)
+	}
+	fd.Close()
+
+	zr, err := zip.OpenReader(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if len(zr.File) == 0 {
+		zr.Close()
+		return nil, errorf("zip file \"%s\" is empty", fileName)
+	}
+	rc, err := zr.File[0].Open()
+	if err != nil {
+		zr.Close()
+		return nil, err
+	}
+	return &zipReadCloser{ReadCloser: rc, zr: zr}, nil
+}
+
+//打开文件, 文件已被压缩则打开压缩文件
+func (r *Reader) openFile(fileName string) error {
+	opened := time.Now()
+	rc, err := openRecordFile(fileName, r.keys)
+	if os.IsNotExist(err) && FileExist(fileName+zipFileSuffix) {
+		fileName = fileName + zipFileSuffix
+		rc, err = openRecordFile(fileName, r.keys)
+	}
+	if err != nil {
+		return err
+	}
+	//文件头不计入行号
+	var head int64
+	baseName := strings.TrimSuffix(fileName, zipFileSuffix)
+	if st, err := loadFileState(baseName); err == nil {
+		head = st.Head
+	} else if lines, ok := indexHeadLines(baseName); ok {
+		head = lines
+	} else if lines, ok := loadHeadLines(baseName); ok {
+		head = lines
+	}
+	r.name, r.rc, r.lineNo, r.offset = fileName, rc, -head, 0
+	r.fd = fileOf(rc)
+	r.live, r.partial = fileName == r.activeName(), nil
+	if cr, ok := rc.(*cryptReader); ok {
+		cr.sealed = !r.live
+	}
+	r.opened = opened
+	r.seq, r.seqNo, _ = fileSeqStart(r.activeName(), baseName)
+	if r.br == nil {
+		r.br = bufio.NewReaderSize(rc, 32768) // 32k
+	} else {
+		r.br.Reset(rc)
+	}
+	return nil
+}
+
+func (r *Reader) closeFile() {
+	if r.rc != nil {
+		r.rc.Close()
+		r.rc, r.fd = nil, nil
+	}
+}
+
+//读取下一条记录, 读完或出错时返回false
+func (r *Reader) Next() bool {
+	if r.err != nil {
+		return false
+	}
+	if r.files == nil {
+		if r.files, r.err = r.Files(); r.err != nil {
+			return false
+		}
+	}
+
+	for {
+		if r.rc == nil {
+			if r.index+1 >= len(r.files) {
+				return false
+			}
+			r.index++
+			if err := r.openFile(r.files[r.index]); err != nil {
+				if os.IsNotExist(err) { //文件已被清理
+					continue
+				}
+				r.err = err
+				return false
+			}
+		}
+
+		if r.frame {
+			data, size, err := readFrame(r.br, &r.partial)
+			if err == nil {
+				r.lineNo++
+				if r.chain && isChainRecord(data) { //跳过哈希链记录
+					r.offset += int64(size)
+					continue
+				}
+				r.rec.Position = Position{FileName: r.name, LineNo: r.lineNo, Offset: r.offset,
+					Seq: r.seqOf(r.lineNo)}
+				if r.live {
+					r.rec.Time = r.opened
+				}
+				r.rec.Data = data
+				r.offset += int64(size)
+				return true
+			}
+			//跟踪读取时活动文件结尾的不完整帧暂存, 待写完后读取
+			if (err == io.EOF || err == io.ErrUnexpectedEOF) && r.holding() {
+				return false
+			}
+			if err == io.EOF {
+				r.closeFile()
+				continue
+			}
+			if err == io.ErrUnexpectedEOF {
+				err = ErrFrameTorn
+			}
+			r.err = &FrameError{FileName: r.name, Offset: r.offset, Err: err}
+			return false
+		}
+
+		line, err := r.br.ReadBytes('\n')
+		if len(r.partial) > 0 {
+			line, r.partial = append(r.partial, line...), nil
+		}
+		//跟踪读取时活动文件结尾的不完整记录暂存, 待写完后读取
+		if (err == io.EOF || err == io.ErrUnexpectedEOF) && r.holding() {
+			if len(line) > 0 {
+				r.partial = line
+			}
+			return false
+		}
+		if len(line) > 0 {
+			r.lineNo++
+			if r.chain && isChainRecord(bytes.TrimSuffix(line, lineSep)) { //跳过哈希链记录
+				r.offset += int64(len(line))
+				continue
+			}
+			r.rec.Position = Position{FileName: r.name, LineNo: r.lineNo, Offset: r.offset,
+				Seq: r.seqOf(r.lineNo)}
+			if r.live { //活动文件以打开时间区分重命名前后的文件
+				r.rec.Time = r.opened
+			}
+			r.rec.Data = bytes.TrimSuffix(line, lineSep)
+			r.offset += int64(len(line))
+			return true
+		}
+		if err == io.EOF {
+			r.closeFile()
+			continue
+		}
+		if err != nil {
+			r.err = err
+			return false
+		}
+	}
+}
+
+//是否停留在活动文件结尾等待新记录
+func (r *Reader) holding() bool {
+	return r.follow && r.live && r.index == len(r.files)-1
+}
+
+//读取当前记录
+func (r *Reader) Record() *Record {
+	return &r.rec
+}
+
+//读取错误信息
+func (r *Reader) Err() error {
+	return r.err
+}
+
+//关闭读取器
+func (r *Reader) Close() error {
+	r.closeFile()
+	r.index = len(r.files)
+	return nil
+}
@@ -0,0 +1,770 @@
+package fwrite
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReader(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "TestReader")
+	w := NewFileWrite("TestReader")
+	_, err := w.Init(false, prefix, "log", "log", "log",
+		true, false, false, false, 2, 0, false, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	pos := make([]Position, 0, 5)
+	for i := 1; i <= 5; i++ {
+		fileName, lineNo, err := w.WriteString(fmt.Sprintf("record-%d\n", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pos = append(pos, Position{FileName: fileName, LineNo: lineNo})
+	}
+
+	//压缩第一个重命名文件
+	files, err := w.NewReader().Files()
+	if err != nil || len(files) != 3 {
+		t.Fatalf("files %v, err %v", files, err)
+	}
+	if err = zipLogFile(files[0], nil); err != nil {
+		t.Fatal(err)
+	}
+
+	r := w.NewReader()
+	defer r.Close()
+	n := 0
+	for r.Next() {
+		rec := r.Record()
+		if want := fmt.Sprintf("record-%d", n+1); string(rec.Data) != want {
+			t.Fatalf("record %d data %q, want %q", n, rec.Data, want)
+		}
+		if rec.LineNo != pos[n].LineNo || rec.Offset != (rec.LineNo-1)*9 {
+			t.Fatalf("record %d position %+v, want line %d", n, rec.Position, pos[n].LineNo)
+		}
+		if n == 0 && rec.FileName != files[0]+zipFileSuffix {
+			t.Fatalf("record 0 file %s, want zip file", rec.FileName)
+		}
+		n++
+	}
+	if r.Err() != nil || n != 5 {
+		t.Fatalf("read %d records, err %v", n, r.Err())
+	}
+}
+
+func TestReaderFollow(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "TestFollow")
+	w := NewFileWrite("TestFollow")
+	_, err := w.Init(false, prefix, "log", "log", "log",
+		true, false, false, false, 100, 0, false, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.WriteString("record-1\n")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	recs := make(chan Record, 16)
+	done := make(chan error, 1)
+	go func() {
+		done <- w.NewReader().Follow(ctx, func(rec *Record) error {
+			recs <- *rec
+			return nil
+		})
+	}()
+
+	//记录分两次写入, 跟踪读取等待换行; 记录间旋转文件
+	for i := 2; i <= 5; i++ {
+		w.WriteString(fmt.Sprintf("record-%d", i))
+		time.Sleep(10 * time.Millisecond)
+		w.WriteString("\n")
+		if i%2 == 0 {
+			w.Rotate()
+		}
+	}
+	for i := 1; i <= 5; i++ {
+		select {
+		case rec := <-recs:
+			if want := fmt.Sprintf("record-%d", i); string(rec.Data) != want {
+				t.Fatalf("follow record %q, want %q", rec.Data, want)
+			}
+		case <-ctx.Done():
+			t.Fatalf("follow timeout at record %d", i)
+		}
+	}
+	//取消后立即返回, 不等待轮询超时
+	time.Sleep(50 * time.Millisecond)
+	canceled := time.Now()
+	cancel()
+	if err = <-done; err != context.Canceled {
+		t.Fatalf("follow err %v", err)
+	}
+	if used := time.Since(canceled); runtime.GOOS == "linux" && used > followPoll/2 {
+		t.Fatalf("follow cancel used %v", used)
+	}
+}
+
+func TestReaderFollowRotations(t *testing.T) {
+	keys := &StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}}
+	for _, crypt := range []bool{false, true} {
+		prefix := filepath.Join(t.TempDir(), "TestFollowRot")
+		w := NewFileWrite("TestFollowRot")
+		if crypt {
+			w.SetKeyProvider(keys)
+		}
+		_, err := w.Init(false, prefix, "log", "log", "log",
+			true, false, false, false, 2, 0, false, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.WriteString("record-1\n")
+
+		//处理首条记录时连续旋转多次, 跟踪读取不能跳过中间的封存文件
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		recs := make(chan Record, 16)
+		wrote := make(chan struct{})
+		done := make(chan error, 1)
+		go func() {
+			done <- w.NewReader().Follow(ctx, func(rec *Record) error {
+				recs <- *rec
+				if string(rec.Data) == "record-1" {
+					<-wrote
+				}
+				return nil
+			})
+		}()
+		<-recs
+		for i := 2; i <= 7; i++ {
+			w.WriteString(fmt.Sprintf("record-%d\n", i))
+		}
+		close(wrote)
+		for i := 2; i <= 7; i++ {
+			select {
+			case rec := <-recs:
+				if want := fmt.Sprintf("record-%d", i); string(rec.Data) != want {
+					t.Fatalf("crypt %v, follow record %q, want %q", crypt, rec.Data, want)
+				}
+			case <-ctx.Done():
+				t.Fatalf("crypt %v, follow timeout at record %d", crypt, i)
+			}
+		}
+		cancel()
+		if err = <-done; err != context.Canceled {
+			t.Fatalf("follow err %v", err)
+		}
+		w.Close()
+	}
+}
+
+func TestReaderSeek(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "TestSeek")
+	w := NewFileWrite("TestSeek")
+	w.SetStateFile(true)
+	_, err := w.Init(false, prefix, "log", "log", "log",
+		true, false, false, false, 3, 0, false, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	pos := make([]Position, 0, 8)
+	for i := 1; i <= 8; i++ {
+		p, err := w.WritePos([]byte(fmt.Sprintf("record-%d\n", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pos = append(pos, p)
+		w.Flush()
+	}
+	if pos[0].FileName != pos[7].FileName {
+		t.Fatalf("active file name changed %s -> %s", pos[0].FileName, pos[7].FileName)
+	}
+	files, _ := w.NewReader().Files()
+	if err = zipLogFile(files[0], nil); err != nil {
+		t.Fatal(err)
+	}
+
+	r := w.NewReader()
+	defer r.Close()
+	for i := len(pos) - 1; i >= 0; i-- {
+		if err = r.Seek(pos[i]); err != nil {
+			t.Fatalf("seek %+v: %v", pos[i], err)
+		}
+		if !r.Next() {
+			t.Fatalf("seek %+v: no record, err %v", pos[i], r.Err())
+		}
+		if want := fmt.Sprintf("record-%d", i+1); string(r.Record().Data) != want {
+			t.Fatalf("seek %+v: record %q, want %q", pos[i], r.Record().Data, want)
+		}
+		if r.Record().Offset != pos[i].Offset {
+			t.Fatalf("seek %+v: offset %d", pos[i], r.Record().Offset)
+		}
+	}
+
+	//未提供写入时间和序号, 活动文件已封存过时无法确定所在文件
+	if _, err = r.Resolve(Position{FileName: pos[0].FileName, LineNo: 1}); err != ErrFileMiss {
+		t.Fatalf("resolve without time, err %v", err)
+	}
+}
+
+func TestReaderIndex(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "TestIndex")
+	w := NewFileWrite("TestIndex")
+	w.SetIndexEvery(2)
+	w.SetFileHeader([]byte("#head\n"), nil, false)
+	_, err := w.Init(false, prefix, "log", "log", "log",
+		true, false, false, false, 100, 0, false, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	var pos Position
+	for i := 1; i <= 7; i++ {
+		p, _ := w.WritePos([]byte(fmt.Sprintf("record-%d\n", i)))
+		if i == 6 {
+			pos = p
+		}
+	}
+	//第6行记录为物理第7行, 之前最近索引项为物理第6行
+	mark, ok := lookupIndex(pos.FileName, 7)
+	if !ok || mark.Line != 6 || mark.Offset != 6+5*9 || pos.LineNo != 6 {
+		t.Fatalf("index mark %+v, ok %v", mark, ok)
+	}
+
+	r := w.NewReader()
+	defer r.Close()
+	if err = r.Seek(pos); err != nil || !r.Next() {
+		t.Fatalf("seek %+v: err %v", pos, err)
+	}
+	if rec := r.Record(); string(rec.Data) != "record-6" || rec.Offset != pos.Offset {
+		t.Fatalf("seek record %+v", rec)
+	}
+}
+
+func TestConsumers(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "TestConsumer")
+	w := NewFileWrite("TestConsumer")
+	_, err := w.Init(false, prefix, "log", "log", "log",
+		true, false, false, false, 2, 0, false, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for i := 1; i <= 5; i++ {
+		w.WriteString(fmt.Sprintf("record-%d\n", i))
+	}
+
+	c := w.Consumers()
+	c.Register("a")
+	c.Register("b")
+	files, _ := w.NewReader().Files()
+	if ok, _ := c.Passed(files[0]); ok {
+		t.Fatalf("uncommitted consumers passed %s", files[0])
+	}
+
+	//a 读完全部记录, b 只读到第二个文件
+	r := w.NewReader()
+	for r.Next() {
+		rec := r.Record()
+		c.Commit("a", rec.Position)
+		if string(rec.Data) == "record-3" {
+			c.Commit("b", rec.Position)
+		}
+	}
+	if ok, _ := c.Passed(files[0]); !ok {
+		t.Fatalf("consumers not passed %s", files[0])
+	}
+	if ok, _ := c.Passed(files[1]); ok {
+		t.Fatalf("consumer b passed %s", files[1])
+	}
+
+	lags, err := c.Lag()
+	if err != nil || len(lags) != 2 {
+		t.Fatalf("lags %v, err %v", lags, err)
+	}
+	if lags[0].Name != "a" || lags[0].Files != 0 || lags[1].Files != 1 {
+		t.Fatalf("lags a %+v, b %+v", lags[0], lags[1])
+	}
+
+	//b 的位置无法定位时不能视为已处理
+	c.Commit("b", Position{FileName: prefix + ".log", LineNo: 1})
+	if ok, _ := c.Passed(files[0]); ok {
+		t.Fatalf("unresolved consumer passed %s", files[0])
+	}
+
+	//多个存储实例(如多个进程)同时注册, 不能丢失注册
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w.Consumers().Register(fmt.Sprintf("c%d", i))
+		}(i)
+	}
+	wg.Wait()
+	if offsets, _ := c.Offsets(); len(offsets) != 10 {
+		t.Fatalf("offsets %d after concurrent register, want 10", len(offsets))
+	}
+}
+
+func TestReaderFrame(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "TestFrame")
+	w := NewFileWrite("TestFrame")
+	w.SetFrameMode(true)
+	w.SetIndexEvery(2)
+	_, err := w.Init(false, prefix, "log", "log", "log",
+		true, false, false, false, 100, 0, false, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	var pos Position
+	for i := 1; i <= 5; i++ {
+		p, _ := w.WritePos([]byte(fmt.Sprintf("bin\n%d\x00", i)))
+		if i == 4 {
+			pos = p
+		}
+	}
+	if n, err := w.cfger.GetFileLines(pos.FileName); n != 5 || err != nil {
+		t.Fatalf("file frames %d, err %v", n, err)
+	}
+
+	r := w.NewReader()
+	if err = r.Seek(pos); err != nil || !r.Next() {
+		t.Fatalf("seek %+v: err %v", pos, err)
+	}
+	if rec := r.Record(); string(rec.Data) != "bin\n4\x00" || rec.LineNo != 4 {
+		t.Fatalf("seek frame %+v", rec)
+	}
+	r.Close()
+
+	//损坏最后一帧
+	w.Flush()
+	fd, _ := os.OpenFile(pos.FileName, os.O_WRONLY, 0)
+	fd.WriteAt([]byte{'X'}, pos.Offset+int64(2*(frameHead+6))-1)
+	fd.Close()
+	if n, err := w.cfger.GetFileLines(pos.FileName); n != 4 || !errors.Is(err, ErrFrameCorrupt) {
+		t.Fatalf("corrupt file frames %d, err %v", n, err)
+	}
+	r = w.NewReader()
+	defer r.Close()
+	n := 0
+	for r.Next() {
+		n++
+	}
+	var fe *FrameError
+	if n != 4 || !errors.As(r.Err(), &fe) || fe.Offset != pos.Offset+frameHead+6 {
+		t.Fatalf("read %d frames, err %v", n, r.Err())
+	}
+}
+
+func TestReaderSeq(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "TestSeq")
+	open := func() *FileWrite {
+		w := NewFileWrite("TestSeq")
+		w.SetSeqMode(true)
+		w.SetFileHeader([]byte("#head\n"), nil, false)
+		_, err := w.Init(false, prefix, "log", "log", "log",
+			true, false, false, false, 3, 0, false, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	w := open()
+	for i := 1; i <= 7; i++ {
+		p, err := w.WritePos([]byte(fmt.Sprintf("record-%d\n", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Seq != uint64(i) {
+			t.Fatalf("record %d seq %d", i, p.Seq)
+		}
+	}
+	w.Close()
+
+	//重启后序号继续递增
+	w = open()
+	defer w.Close()
+	if p, _ := w.WritePos([]byte("record-8\n")); p.Seq != 8 {
+		t.Fatalf("record 8 seq %d after restart", p.Seq)
+	}
+	//每条记录一个序号: 未以换行结尾的记录补齐换行, 多行记录被拒绝
+	if p, _ := w.WritePos([]byte("record-9")); p.Seq != 9 {
+		t.Fatalf("unterminated record seq %d", p.Seq)
+	}
+	if _, err := w.WritePos([]byte("x\ny\n")); err != ErrNewline {
+		t.Fatalf("multi-line record err %v", err)
+	}
+	batch := [][]byte{[]byte("record-10\n"), []byte("record-11")}
+	if _, _, _, err := w.WriteBatch(batch); err != nil || w.Seq() != 11 {
+		t.Fatalf("batch seq %d, err %v", w.Seq(), err)
+	}
+	w.Flush()
+
+	r := w.NewReader()
+	defer r.Close()
+	for seq := uint64(11); seq >= 1; seq-- {
+		if err := r.SeekSeq(seq); err != nil {
+			t.Fatalf("seek seq %d: %v", seq, err)
+		}
+		if !r.Next() {
+			t.Fatalf("seek seq %d: no record, err %v", seq, r.Err())
+		}
+		rec := r.Record()
+		if want := fmt.Sprintf("record-%d", seq); string(rec.Data) != want || rec.Seq != seq {
+			t.Fatalf("seek seq %d: record %q seq %d", seq, rec.Data, rec.Seq)
+		}
+	}
+}
+
+func TestReaderChain(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "TestChain")
+	key := []byte("audit-key")
+	open := func() *FileWrite {
+		w := NewFileWrite("TestChain")
+		w.SetChain(2, key)
+		w.SetFileHeader([]byte("#head\n"), nil, false)
+		_, err := w.Init(false, prefix, "log", "log", "log",
+			true, false, false, false, 4, 0, false, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	w := open()
+	for i := 1; i <= 5; i++ {
+		w.WriteString(fmt.Sprintf("record-%d\n", i))
+	}
+	w.Close()
+	w = open()
+	defer w.Close()
+	for i := 6; i <= 9; i++ {
+		w.Write([]byte(fmt.Sprintf("record-%d\n", i)))
+	}
+	w.Flush()
+	if stat, _ := os.Stat(w.cfg.FileName); stat.Size() != w.cfg.CurSize {
+		t.Fatalf("file size %d, CurSize %d", stat.Size(), w.cfg.CurSize)
+	}
+
+	//链记录不作为记录读出, 行号连续
+	r := w.NewReader()
+	n := 0
+	for r.Next() {
+		if r.Record().LineNo <= 0 || string(r.Record().Data) == "#head" {
+			continue
+		}
+		n++
+		if want := fmt.Sprintf("record-%d", n); string(r.Record().Data) != want {
+			t.Fatalf("record %d data %q", n, r.Record().Data)
+		}
+	}
+	r.Close()
+	if n != 9 || r.Err() != nil {
+		t.Fatalf("read %d records, err %v", n, r.Err())
+	}
+
+	report, err := w.NewReader().VerifyChain(key)
+	if err != nil || report.Files < 3 || report.Chains < 5 {
+		t.Fatalf("verify report %+v, err %v", report, err)
+	}
+	if _, err = w.NewReader().VerifyChain([]byte("other")); !errors.Is(err, ErrChainHash) {
+		t.Fatalf("verify with other key err %v", err)
+	}
+
+	//删除封存文件结尾的链记录
+	files, _ := w.NewReader().Files()
+	data, _ := os.ReadFile(files[0])
+	os.WriteFile(files[0], data[:len(data)-chainLen-1], 0660)
+	_, err = w.NewReader().VerifyChain(key)
+	if !errors.Is(err, ErrChainOpen) {
+		t.Fatalf("verify truncated err %v", err)
+	}
+
+	//篡改第一个文件的记录
+	os.WriteFile(files[0], bytes.Replace(data, []byte("record-2"), []byte("record-X"), 1), 0660)
+	_, err = w.NewReader().VerifyChain(key)
+	var ce *ChainError
+	if !errors.As(err, &ce) || ce.FileName != files[0] || ce.Err != ErrChainHash {
+		t.Fatalf("verify tampered err %v", err)
+	}
+
+	//生成的文件结束记录由封存链记录覆盖, 篡改后校验失败
+	prefix = filepath.Join(t.TempDir(), "TestChainTrailer")
+	w2 := NewFileWrite("TestChainTrailer")
+	w2.SetChain(2, key)
+	w2.SetFileTrailer(nil, func(st *FileStats) []byte {
+		return []byte(fmt.Sprintf("#END records=%d\n", st.Records))
+	})
+	if _, err = w2.Init(false, prefix, "log", "log", "log",
+		true, false, false, false, 100, 0, false, 3); err != nil {
+		t.Fatal(err)
+	}
+	defer w2.Close()
+	for i := 1; i <= 3; i++ {
+		w2.WriteString(fmt.Sprintf("record-%d\n", i))
+	}
+	w2.Rotate()
+	if _, err = w2.NewReader().VerifyChain(key); err != nil {
+		t.Fatalf("verify trailer err %v", err)
+	}
+	files, _ = w2.NewReader().Files()
+	data, _ = os.ReadFile(files[0])
+	os.WriteFile(files[0], bytes.Replace(data, []byte("records=3"), []byte("records=9"), 1), 0660)
+	if _, err = w2.NewReader().VerifyChain(key); !errors.Is(err, ErrChainHash) {
+		t.Fatalf("verify tampered trailer err %v", err)
+	}
+
+	//记录写入失败时不写链记录, 撤销链记录计数
+	lines, size := w2.cfg.CurLines, w2.cfg.CurSize
+	w2.WriteString("record-4\n")
+	w2.muwt.file.Close()
+	if _, _, err = w2.WriteString("record-5\n"); err == nil {
+		t.Fatal("write to closed file")
+	}
+	if w2.cfg.CurLines != lines+2 || w2.cfg.CurSize != size+18 {
+		t.Fatalf("failed write lines %d, size %d", w2.cfg.CurLines-lines, w2.cfg.CurSize-size)
+	}
+}
+
+func TestReaderCrypt(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "TestCrypt")
+	keys := &StaticKeys{Current: "k1", Keys: map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 16),
+	}}
+	open := func() *FileWrite {
+		w := NewFileWrite("TestCrypt")
+		w.SetKeyProvider(keys)
+		w.SetStateFile(true)
+		w.SetIndexEvery(2)
+		_, err := w.Init(false, prefix, "log", "log", "log",
+			true, false, false, false, 3, 0, false, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	w := open()
+	for i := 1; i <= 4; i++ {
+		w.WriteString(fmt.Sprintf("record-%d\n", i))
+	}
+	w.muwt.file.Close()
+
+	//轮换密钥后重启, 活动文件仍以原密钥续写, 新文件使用新密钥
+	keys.Current = "k2"
+	w = open()
+	defer w.Close()
+	var pos Position
+	for i := 5; i <= 8; i++ {
+		p, _ := w.WritePos([]byte(fmt.Sprintf("record-%d\n", i)))
+		if i == 8 {
+			pos = p
+		}
+	}
+	w.Flush()
+	if size, _ := w.muwt.FileSize(); size != w.cfg.CurSize {
+		t.Fatalf("plain size %d, CurSize %d", size, w.cfg.CurSize)
+	}
+
+	files, _ := w.NewReader().Files()
+	if len(files) != 3 {
+		t.Fatalf("files %v", files)
+	}
+	for i, fileName := range files {
+		data, _ := os.ReadFile(fileName)
+		if bytes.Contains(data, []byte("record")) {
+			t.Fatalf("file %s not encrypted", fileName)
+		}
+		if keyId := []byte{'k', byte('1' + i/2)}; !bytes.Contains(data[:16], keyId) {
+			t.Fatalf("file %s key id not %s", fileName, keyId)
+		}
+	}
+	if err := zipLogFile(files[0], keys); err != nil {
+		t.Fatal(err)
+	}
+
+	r := w.NewReader()
+	n := 0
+	for r.Next() {
+		n++
+		if want := fmt.Sprintf("record-%d", n); string(r.Record().Data) != want {
+			t.Fatalf("record %d data %q", n, r.Record().Data)
+		}
+	}
+	r.Close()
+	if n != 8 || r.Err() != nil {
+		t.Fatalf("read %d records, err %v", n, r.Err())
+	}
+
+	//加密压缩文件流式解压, 末尾截断时报告截断
+	zipName := files[0] + zipFileSuffix
+	data, _ := os.ReadFile(zipName)
+	os.WriteFile(zipName, data[:len(data)-cryptChunkHead-cryptTagSize], 0660)
+	rc, err := openRecordFile(zipName, keys)
+	if err == nil {
+		_, err = io.ReadAll(rc)
+		rc.Close()
+	}
+	if !errors.Is(err, ErrCryptTruncated) {
+		t.Fatalf("read truncated zip err %v", err)
+	}
+	os.WriteFile(zipName, data, 0660)
+
+	r = w.NewReader()
+	defer r.Close()
+	if err := r.Seek(pos); err != nil || !r.Next() || string(r.Record().Data) != "record-8" {
+		t.Fatalf("seek %+v: err %v", pos, err)
+	}
+
+	//无密钥无法读取
+	r = NewFileReader(prefix, "log", "log", "log")
+	if r.Next() || !errors.Is(r.Err(), ErrKeyMiss) {
+		t.Fatalf("read without keys err %v", r.Err())
+	}
+
+	//重启续写使用新的随机数基值, 各密文块随机数不重复
+	nonces := make(map[string]string)
+	for _, fileName := range files[1:] {
+		data, _ := os.ReadFile(fileName)
+		off := len(cryptMagic) + 2 + int(binary.BigEndian.Uint16(data[len(cryptMagic):]))
+		base, n, sessions := data[off:off+cryptNonceSize], uint64(0), 0
+		for off += cryptNonceSize; off+cryptChunkHead <= len(data); {
+			head := binary.BigEndian.Uint32(data[off:])
+			l := int(head & cryptLenMask)
+			if head&cryptSession != 0 {
+				base, n, sessions = data[off+cryptChunkHead:off+cryptChunkHead+l], 0, sessions+1
+			} else {
+				nonce := fmt.Sprintf("%x/%d", base, n)
+				if other, ok := nonces[nonce]; ok {
+					t.Fatalf("nonce %s reused in %s and %s", nonce, other, fileName)
+				}
+				nonces[nonce], n = fileName, n+1
+			}
+			off += cryptChunkHead + l
+		}
+		if fileName == files[1] && sessions != 1 {
+			t.Fatalf("file %s sessions %d after restart", fileName, sessions)
+		}
+	}
+
+	//封存文件在块边界截断时报告截断
+	data, _ = os.ReadFile(files[1])
+	os.WriteFile(files[1], data[:len(data)-cryptChunkHead-cryptTagSize], 0660)
+	r = w.NewReader()
+	for r.Next() {
+	}
+	if !errors.Is(r.Err(), ErrCryptTruncated) {
+		t.Fatalf("read truncated err %v", r.Err())
+	}
+
+	//超过最大块尺寸的数据分为多块写入
+	ws, _ := newCryptStream(keys.Keys["k1"], make([]byte, cryptNonceSize))
+	rs, _ := newCryptStream(keys.Keys["k1"], make([]byte, cryptNonceSize))
+	plain, err := io.ReadAll(&cryptReader{rd: bytes.NewReader(ws.sealAll(make([]byte, cryptMaxPlain+1))), s: rs})
+	if err != nil || len(plain) != cryptMaxPlain+1 || rs.n != 2 {
+		t.Fatalf("read large chunks %d, chunks %d, err %v", len(plain), rs.n, err)
+	}
+}
+
+func TestReaderCryptSession(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "TestCryptSession")
+	keys := &StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}}
+	open := func() *FileWrite {
+		w := NewFileWrite("TestCryptSession")
+		w.cfg.RotateRenameSuffix = true //不重命名, 关闭后续写同一文件
+		w.SetKeyProvider(keys)
+		_, err := w.Init(false, prefix, "log", "log", "log",
+			true, false, false, false, 100, 0, false, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	w := open()
+	w.WriteString("record-1\n")
+	w.Close()
+	data, _ := os.ReadFile(prefix + ".log")
+	boundary := len(data) - cryptChunkHead - cryptTagSize
+
+	//续写时截断原结束块, 已读到结束块的跟踪读取从该位置继续
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	recs := make(chan string, 4)
+	done := make(chan error, 1)
+	go func() {
+		r := NewFileReader(prefix, "log", "log", "log")
+		r.SetKeyProvider(keys)
+		done <- r.Follow(ctx, func(rec *Record) error {
+			recs <- string(rec.Data)
+			return nil
+		})
+	}()
+	for i := 1; i <= 2; i++ {
+		select {
+		case data := <-recs:
+			if want := fmt.Sprintf("record-%d", i); data != want {
+				t.Fatalf("follow record %q, want %q", data, want)
+			}
+		case err := <-done:
+			t.Fatalf("follow err %v", err)
+		case <-ctx.Done():
+			t.Fatalf("follow timeout at record %d", i)
+		}
+		if i == 1 {
+			time.Sleep(50 * time.Millisecond)
+			w = open()
+			w.WriteString("record-2\n")
+			w.Flush()
+		}
+	}
+	cancel()
+	<-done
+	w.Close()
+
+	data, _ = os.ReadFile(prefix + ".log")
+	if head := binary.BigEndian.Uint32(data[boundary:]); head&cryptSession == 0 {
+		t.Fatalf("chunk 0x%x before session record", head)
+	}
+	read := func(data []byte) error {
+		rs, size, err := readCryptHeader(bytes.NewReader(data), keys)
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadAll(&cryptReader{rd: bytes.NewReader(data[size:]), s: rs, sealed: true})
+		return err
+	}
+	if err := read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	//封存文件在会话边界截断时报告截断
+	if err := read(data[:boundary]); !errors.Is(err, ErrCryptTruncated) {
+		t.Fatalf("read truncated at session err %v", err)
+	}
+
+	//密文块不能移到其它会话
+	moved := append(append([]byte{}, data[:boundary]...), data[boundary+cryptChunkHead+cryptNonceSize:]...)
+	if err := read(moved); !errors.Is(err, ErrCryptCorrupt) {
+		t.Fatalf("read moved chunk err %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+// fileRepair
+package fwrite
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+//尾部修复方式
+const (
+	RepairNone     = iota //不修复
+	RepairTruncate        //截断不完整记录
+	RepairSeal            //以标记行封存不完整记录
+)
+
+const (
+	TornSuffix = ".torn" //帧模式封存时保存不完整数据的文件后缀, 每次封存追加一帧
+)
+
+var (
+	DefaultTornMarker = []byte("#TORN\n") //默认封存标记
+)
+
+//尾部修复报告
+type RepairReport struct {
+	FileName string    //文件名
+	Size     int64     //修复前尺寸
+	Offset   int64     //完整记录结束偏移
+	Torn     int64     //不完整记录字节数
+	Action   string    //修复动作: truncate, seal, 未修复为none
+	Time     time.Time //修复时间
+	Err      error     //未修复原因, 如损坏帧不是文件结尾的不完整帧
+}
+
+//获取尾部修复方式和封存标记
+func (c *FileConfig) GetTailRepair() (int, []byte) {
+	if len(c.TornMarker) == 0 {
+		return c.TailRepair, DefaultTornMarker
+	}
+	return c.TailRepair, c.TornMarker
+}
+
+//查找最后完整行结束偏移
+//fd      	输入文件
+//fileSize	输入文件尺寸
+func lineTailOffset(fd *os.File, fileSize int64) (int64, error) {
+	buf := make([]byte, 4096)
+	for end := fileSize; end > 0; {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		n, err := fd.ReadAt(buf[:end-start], start)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+//查找最后完整帧结束偏移, 从状态检查点或索引项开始检查,
+//只有文件结尾的不完整帧可以修复, 其它损坏返回FrameError
+//fileName	输入文件名
+//fileSize	输入文件尺寸
+func frameTailOffset(fileName string, fileSize int64) (int64, error) {
+	var start int64
+	if st, err := loadFileState(fileName); err == nil {
+		if mark := findFileMark(st.Marks, math.MaxInt64); mark.Offset <= fileSize {
+			start = mark.Offset
+		}
+	}
+	if mark, ok := lookupIndex(fileName, math.MaxInt64); ok &&
+		mark.Offset > start && mark.Offset <= fileSize {
+		start = mark.Offset
+	}
+
+	fd, err := os.Open(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+	if _, err = fd.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+	_, valid, err := walkFrames(io.LimitReader(fd, fileSize-start), nil)
+	if err != nil && err != ErrFrameTorn {
+		return start + valid, &FrameError{FileName: fileName, Offset: start + valid, Err: err}
+	}
+	return start + valid, nil
+}
+
+//修复重新打开文件的尾部不完整记录(已持有锁)
+//fd      	输入已打开文件
+//fileName	输入文件名
+//fileSize	输入文件尺寸
+//size    	输出修复后尺寸
+func (mw *MutexWrite) repairTail(fd *os.File, fileName string, fileSize int64) (size int64) {
+	mode, marker := mw.cfger.GetTailRepair()
+	if mode == RepairNone || fileSize == 0 || mw.crypt != nil { //加密文件已截断不完整密文块
+		return fileSize
+	}
+
+	frameMode := mw.cfger.IsFrameMode()
+	if !frameMode && !mw.cfger.IsLineEnded() { //记录不保证以换行结尾, 无法识别不完整行
+		return fileSize
+	}
+	var valid int64
+	var err error
+	if frameMode {
+		valid, err = frameTailOffset(fileName, fileSize)
+	} else {
+		valid, err = lineTailOffset(fd, fileSize)
+	}
+	if fe, ok := err.(*FrameError); ok {
+		//损坏帧之后可能还有完整帧, 不修改数据
+		mw.repair = &RepairReport{FileName: fileName, Size: fileSize, Offset: valid,
+			Torn: fileSize - valid, Action: "none", Time: time.Now(), Err: fe}
+		mw.log(LevelError, "check tail", fileName, fe)
+		return fileSize
+	}
+	if err != nil {
+		mw.log(LevelError, "check tail", fileName, err)
+		return fileSize
+	}
+	if valid >= fileSize {
+		return fileSize
+	}
+
+	report := &RepairReport{FileName: fileName, Size: fileSize, Offset: valid,
+		Torn: fileSize - valid, Action: "truncate", Time: time.Now()}
+	size = valid
+	switch {
+	case mode == RepairSeal && !frameMode:
+		//补齐换行符后写入标记行
+		n, e := fd.Write(append([]byte{'\n'}, marker...))
+		size, err, report.Action = fileSize+int64(n), e, "seal"
+	case mode == RepairSeal && frameMode:
+		//不完整数据作为一帧追加保存后截断, 写入标记帧
+		torn := make([]byte, fileSize-valid)
+		if _, err = fd.ReadAt(torn, valid); err == nil {
+			err = appendTorn(fileName, torn)
+		}
+		if err == nil {
+			if err = fd.Truncate(valid); err == nil {
+				n, e := fd.Write(appendFrame(nil, marker))
+				size, err, report.Action = valid+int64(n), e, "seal"
+			}
+		}
+	default:
+		err = fd.Truncate(valid)
+	}
+	if err != nil {
+		mw.log(LevelError, "repair tail", fileName, err)
+		if stat, e := fd.Stat(); e == nil {
+			size = stat.Size()
+		}
+	}
+
+	mw.repair = report
+	mw.log(LevelWarn, "repair tail", fileName, nil, Field{"torn", report.Torn},
+		Field{"offset", report.Offset}, Field{"action", report.Action})
+	return size
+}
+
+//追加不完整数据到.torn文件, 每次修复一帧, 不覆盖之前保存的数据
+//fileName	输入数据文件名
+//torn    	输入不完整数据
+func appendTorn(fileName string, torn []byte) error {
+	fd, err := os.OpenFile(fileName+TornSuffix, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0660)
+	if err != nil {
+		return err
+	}
+	_, err = fd.Write(appendFrame(nil, torn))
+	if e := fd.Sync(); err == nil {
+		err = e
+	}
+	if e := fd.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+//读取最后一次尾部修复报告, 未修复返回nil
+func (mw *MutexWrite) LastRepair() *RepairReport {
+	if mw == nil {
+		return nil
+	}
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
+	if mw.repair == nil {
+		return nil
+	}
+	report := *mw.repair
+	return &report
+}
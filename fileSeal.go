@@ -0,0 +1,124 @@
+// fileSeal
+package fwrite
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	NamesSuffix = ".names" //重命名日志后缀
+)
+
+var (
+	namesMu sync.Mutex //重命名日志互斥
+
+	//随文件封存一起重命名的附属文件后缀
+	sealSuffixes = []string{StateSuffix, IndexSuffix, HeadSuffix, TornSuffix}
+)
+
+//重命名日志记录
+type nameEntry struct {
+	From   string    `json:"from"`   //原文件名
+	To     string    `json:"to"`     //重命名文件名
+	Sealed time.Time `json:"sealed"` //封存时间
+	Size   int64     `json:"size"`   //文件尺寸
+
+	FirstSeq uint64 `json:"firstSeq,omitempty"` //首个全局序号
+	SeqLine  int64  `json:"seqLine,omitempty"`  //首个全局序号所在行号
+	LastSeq  uint64 `json:"lastSeq,omitempty"`  //最后全局序号
+}
+
+//文件封存重命名后处理: 移动附属文件, 记录重命名日志
+//from	输入原文件名
+//to  	输入重命名文件名
+func sealRename(from, to string) {
+	for _, suffix := range sealSuffixes {
+		if FileExist(from + suffix) {
+			os.Rename(from+suffix, to+suffix)
+		}
+	}
+
+	entry := nameEntry{From: filepath.Base(from), To: filepath.Base(to), Sealed: time.Now()}
+	if stat, err := os.Stat(to); err == nil {
+		entry.Size = stat.Size()
+	}
+	if st, err := loadFileState(to); err == nil && st.FirstSeq > 0 {
+		entry.FirstSeq, entry.SeqLine, entry.LastSeq = st.FirstSeq, st.SeqLine, st.LastSeq
+	}
+	data, _ := json.Marshal(&entry)
+	data = append(data, '\n')
+
+	namesMu.Lock()
+	defer namesMu.Unlock()
+	fd, err := os.OpenFile(from+NamesSuffix, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0660)
+	if err != nil {
+		logEvent(DefaultLogger(), LevelError, "", "open names", from+NamesSuffix, err)
+		return
+	}
+	fd.Write(data)
+	fd.Close()
+}
+
+//读取重命名日志
+//activeName	输入活动文件名
+func loadNames(activeName string) ([]*nameEntry, error) {
+	namesMu.Lock()
+	defer namesMu.Unlock()
+
+	data, err := os.ReadFile(activeName + NamesSuffix)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	entries := make([]*nameEntry, 0, 64)
+	scan := bufio.NewScanner(bytes.NewReader(data))
+	for scan.Scan() {
+		entry := new(nameEntry)
+		if json.Unmarshal(scan.Bytes(), entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+//清理重命名日志, 删除文件已不存在的记录
+//activeName	输入活动文件名
+func pruneNames(activeName string) error {
+	entries, err := loadNames(activeName)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+
+	dir := filepath.Dir(activeName)
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		to := filepath.Join(dir, entry.To)
+		if FileExist(to) || FileExist(to+zipFileSuffix) {
+			data, _ := json.Marshal(entry)
+			buf.Write(data)
+			buf.WriteByte('\n')
+		}
+	}
+
+	namesMu.Lock()
+	defer namesMu.Unlock()
+	return replaceFile(activeName+NamesSuffix, buf.Bytes())
+}
+
+//删除文件的附属文件
+//fileName	输入已删除的文件名
+func removeSidecars(fileName string) {
+	fileName = strings.TrimSuffix(fileName, zipFileSuffix)
+	for _, suffix := range sealSuffixes {
+		os.Remove(fileName + suffix)
+	}
+}
@@ -0,0 +1,153 @@
+// fileSeek
+package fwrite
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//检查文件或其压缩文件是否存在
+//fileName	输入文件名
+func existFileName(fileName string) (string, error) {
+	if FileExist(fileName) {
+		return fileName, nil
+	}
+	if FileExist(fileName + zipFileSuffix) {
+		return fileName + zipFileSuffix, nil
+	}
+	return "", ErrFileMiss
+}
+
+//解析记录位置的当前文件名, 文件重命名或压缩后仍可定位
+//pos	输入记录位置, 文件名为活动文件时按写入时间或全局序号查找重命名日志,
+//      	都未提供且活动文件已封存过时无法确定所在文件, 返回ErrFileMiss
+func (r *Reader) Resolve(pos Position) (string, error) {
+	active := r.activeName()
+	if filepath.Clean(pos.FileName) != filepath.Clean(active) {
+		return existFileName(pos.FileName)
+	}
+
+	entries, err := loadNames(active)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if entry.From != filepath.Base(active) {
+			continue
+		}
+		switch {
+		case !pos.Time.IsZero():
+			//写入后首次封存的文件即为记录所在文件
+			if entry.Sealed.Before(pos.Time) {
+				continue
+			}
+		case pos.Seq > 0:
+			if pos.Seq < entry.FirstSeq || pos.Seq > entry.LastSeq {
+				continue
+			}
+		default:
+			//活动文件已封存过, 记录可能在任一封存文件中
+			return "", ErrFileMiss
+		}
+		return existFileName(filepath.Join(filepath.Dir(active), entry.To))
+	}
+	return existFileName(active)
+}
+
+//定位到记录位置, 之后Next从该记录开始读取,
+//有检查点时从检查点开始扫描, 不从文件开始扫描
+//pos	输入记录位置, 如Write或WritePos返回的文件名和行号
+func (r *Reader) Seek(pos Position) error {
+	if pos.LineNo < 1 {
+		return errorf("seek, lineNo %d less than 1", pos.LineNo)
+	}
+	fileName, err := r.Resolve(pos)
+	if err != nil {
+		return err
+	}
+	files, err := r.Files()
+	if err != nil {
+		return err
+	}
+
+	index := -1
+	for i, f := range files {
+		if filepath.Clean(f) == filepath.Clean(fileName) {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		files, index = append(files, fileName), len(files)
+	}
+
+	r.closeFile()
+	r.files, r.index, r.err = files, index, nil
+	if err = r.openFile(fileName); err != nil {
+		return err
+	}
+
+	//跳到索引项或检查点
+	baseName := strings.TrimSuffix(fileName, zipFileSuffix)
+	var mark FileMark
+	if st, e := loadFileState(baseName); e == nil {
+		mark = findFileMark(st.Marks, pos.LineNo)
+	}
+	head := -r.lineNo
+	if m, ok := lookupIndex(baseName, pos.LineNo+head); ok && m.Offset > mark.Offset {
+		mark = FileMark{Line: m.Line - head, Offset: m.Offset}
+	}
+	if mark.Offset > 0 {
+		if err = r.skipTo(mark); err != nil {
+			return err
+		}
+	}
+
+	//扫描到目标行
+	for r.frame && r.lineNo+1 < pos.LineNo {
+		_, size, err := readFrame(r.br, &r.partial)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errorf("seek, lineNo %d beyond \"%s\" frames %d",
+				pos.LineNo, fileName, r.lineNo)
+		}
+		if err != nil {
+			return &FrameError{FileName: fileName, Offset: r.offset, Err: err}
+		}
+		r.offset += int64(size)
+		r.lineNo++
+	}
+	for !r.frame && r.lineNo+1 < pos.LineNo {
+		line, err := r.br.ReadSlice('\n')
+		r.offset += int64(len(line))
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF {
+			return errorf("seek, lineNo %d beyond \"%s\" lines %d",
+				pos.LineNo, fileName, r.lineNo)
+		}
+		if err != nil {
+			return err
+		}
+		r.lineNo++
+	}
+	return nil
+}
+
+//跳到检查点
+//mark	输入检查点
+func (r *Reader) skipTo(mark FileMark) error {
+	if fd, ok := r.rc.(*os.File); ok {
+		if _, err := fd.Seek(mark.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		r.br.Reset(fd)
+	} else if _, err := io.CopyN(io.Discard, r.br, mark.Offset); err != nil {
+		return err
+	}
+	r.lineNo, r.offset = mark.Line, mark.Offset
+	return nil
+}
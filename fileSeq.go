@@ -0,0 +1,116 @@
+// fileSeq
+package fwrite
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+//设置是否分配全局序号, 序号跨文件和重启单调递增, 保存于状态文件,
+//需在Init之前设置
+//	每条记录分配一个序号, 由WritePos返回; 行模式下每条记录必须恰为一行,
+//	含内嵌换行符的记录返回ErrNewline(可用NewlineEscape转义), 未以换行结尾的记录补齐换行
+func (w *FileWrite) SetSeqMode(seqMode bool) {
+	w.mu.Lock()
+	w.cfg.SeqMode = seqMode
+	w.mu.Unlock()
+}
+
+//读取最后分配的全局序号
+func (w *FileWrite) Seq() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.cfg.CurSeq
+}
+
+//文件旋转后初始化全局序号(已持有锁)
+func (w *FileWrite) rotateSeq() {
+	if !w.cfg.SeqMode || w.muwt.IsStdout() {
+		return
+	}
+
+	//重新打开的文件, 按当前行数推算最后序号
+	if w.cfg.CurSize > 0 {
+		if st, err := loadFileState(w.cfg.FileName); err == nil && st.FirstSeq > 0 {
+			w.cfg.FileSeq, w.cfg.SeqLine = st.FirstSeq, st.SeqLine
+			last := st.FirstSeq - 1
+			if n := w.cfg.CurLines - st.SeqLine + 1; n > 0 {
+				last += uint64(n)
+			}
+			if last > w.cfg.CurSeq {
+				w.cfg.CurSeq = last
+			}
+			return
+		}
+	}
+
+	//首次打开时从重命名日志恢复
+	if w.cfg.CurSeq == 0 {
+		w.cfg.CurSeq = lastNameSeq(w.cfg.FileName)
+	}
+	w.cfg.FileSeq, w.cfg.SeqLine = w.cfg.CurSeq+1, w.cfg.CurLines+1
+}
+
+//读取重命名日志中最后的全局序号
+//activeName	输入活动文件名
+func lastNameSeq(activeName string) (seq uint64) {
+	entries, err := loadNames(activeName)
+	if err != nil {
+		logEvent(DefaultLogger(), LevelError, "", "load names", activeName+NamesSuffix, err)
+		return 0
+	}
+	for _, entry := range entries {
+		if entry.LastSeq > seq {
+			seq = entry.LastSeq
+		}
+	}
+	return
+}
+
+//读取文件首个全局序号及所在行号, 优先读取状态文件, 其次读取重命名日志
+//activeName	输入活动文件名
+//fileName  	输入数据文件名, 不含压缩后缀
+func fileSeqStart(activeName, fileName string) (seq uint64, lineNo int64, ok bool) {
+	if st, err := loadFileState(fileName); err == nil && st.FirstSeq > 0 {
+		return st.FirstSeq, st.SeqLine, true
+	}
+	entries, _ := loadNames(activeName)
+	for _, entry := range entries {
+		if entry.To == filepath.Base(fileName) && entry.FirstSeq > 0 {
+			return entry.FirstSeq, entry.SeqLine, true
+		}
+	}
+	return 0, 0, false
+}
+
+//计算当前文件行号的全局序号, 未分配为0
+//lineNo	输入行号
+func (r *Reader) seqOf(lineNo int64) uint64 {
+	if r.seq == 0 || lineNo < r.seqNo {
+		return 0
+	}
+	return r.seq + uint64(lineNo-r.seqNo)
+}
+
+//定位到全局序号, 之后Next从该记录开始读取, 无需知道记录所在文件
+//seq	输入全局序号, 如WritePos返回的序号
+func (r *Reader) SeekSeq(seq uint64) error {
+	if seq < 1 {
+		return errorf("seek, seq %d less than 1", seq)
+	}
+	files, err := r.Files()
+	if err != nil {
+		return err
+	}
+
+	//从后向前查找首个序号不大于seq的文件
+	active := r.activeName()
+	for i := len(files) - 1; i >= 0; i-- {
+		first, lineNo, ok := fileSeqStart(active, strings.TrimSuffix(files[i], zipFileSuffix))
+		if !ok || first > seq {
+			continue
+		}
+		return r.Seek(Position{FileName: files[i], LineNo: lineNo + int64(seq-first), Seq: seq})
+	}
+	return errorf("seek, seq %d not found", seq)
+}
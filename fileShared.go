@@ -0,0 +1,314 @@
+// fileShared
+package fwrite
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+
+	flock "github.com/yireyun/go-flock"
+)
+
+const (
+	sharedStateSize = 4096 //锁文件状态最大尺寸
+)
+
+var (
+	ErrSharedConfig = errors.New("shared mode config conflict") //共享模式配置冲突
+)
+
+//共享模式锁文件中保存的活动文件状态
+type sharedState struct {
+	File  string `json:"file"`  //活动文件名
+	Size  int64  `json:"size"`  //活动文件尺寸
+	Lines int64  `json:"lines"` //活动文件行数
+	Day   int    `json:"day"`   //活动文件日期
+}
+
+//设置多进程共享模式, 须在Init前设置
+//	多个进程以O_APPEND方式追加写入同一活动文件, 每次写入持有锁文件的咨询锁,
+//	行号、尺寸和切换日期保存在锁文件中, 由持锁进程执行文件切换,
+//	其它进程发现活动文件被重命名(inode变化)后重新打开新文件
+func (w *FileWrite) SetSharedMode(sharedMode bool) {
+	w.mu.Lock()
+	w.cfg.SharedMode = sharedMode
+	w.mu.Unlock()
+}
+
+//检查共享模式配置, 依赖进程内状态的功能不能与共享模式同时使用
+func (c *FileConfig) checkShared() error {
+	switch {
+	case c.FileLock:
+		return errorf("%w: FileLock", ErrSharedConfig)
+	case c.LockStrict:
+		return errorf("%w: LockStrict", ErrSharedConfig)
+	case c.ZeroSize:
+		return errorf("%w: ZeroSize", ErrSharedConfig)
+	case c.StateFile:
+		return errorf("%w: StateFile", ErrSharedConfig)
+	case c.IndexEvery > 0:
+		return errorf("%w: IndexEvery", ErrSharedConfig)
+	case c.SeqMode:
+		return errorf("%w: SeqMode", ErrSharedConfig)
+	case c.ChainEvery > 0:
+		return errorf("%w: ChainEvery", ErrSharedConfig)
+	case c.KeyProvider != nil:
+		return errorf("%w: KeyProvider", ErrSharedConfig)
+	case c.TrailerFunc != nil:
+		return errorf("%w: TrailerFunc", ErrSharedConfig)
+	case c.Manifest:
+		return errorf("%w: Manifest", ErrSharedConfig)
+	}
+	return nil
+}
+
+//获取活动文件锁文件名
+func (c *FileConfig) lockName() string {
+	return c.GetActiveName() + LockSuffix
+}
+
+//读取锁文件中的活动文件状态, 状态以换行结束, 之后是较长旧状态的残留,
+//锁文件为空或损坏时返回空状态
+//fd 	输入锁文件
+//buf	输入读取缓存
+func loadShared(fd *os.File, buf []byte) (st sharedState) {
+	n, _ := fd.ReadAt(buf, 0)
+	if end := bytes.IndexByte(buf[:n], '\n'); end > 0 {
+		if json.Unmarshal(buf[:end], &st) != nil {
+			st = sharedState{}
+		}
+	}
+	return
+}
+
+//保存活动文件状态到锁文件(已持有锁), 以换行结束覆盖写入文件开头, 不截断文件,
+//每次写入只写状态本身, 不补齐固定尺寸
+func (w *FileWrite) saveShared() error {
+	st := sharedState{
+		File:  w.cfg.FileName,
+		Size:  w.cfg.CurSize,
+		Lines: w.cfg.CurLines,
+		Day:   w.cfg.CurDay,
+	}
+	data, _ := json.Marshal(&st)
+	if len(data) >= sharedStateSize {
+		return errorf("shared state size %d over %d", len(data), sharedStateSize)
+	}
+	_, err := w.sharedFd.WriteAt(append(data, '\n'), 0)
+	return err
+}
+
+//加锁共享模式锁文件(已持有w.mu)
+func (w *FileWrite) lockShared() error {
+	if w.sharedFd == nil {
+		fd, err := os.OpenFile(w.cfg.lockName(), os.O_RDWR|os.O_CREATE, 0660)
+		if err != nil {
+			return errorf("%s open \"%s\" error: %v", w._Name_, w.cfg.lockName(), err)
+		}
+		w.sharedFd, w.sharedBuf = fd, make([]byte, sharedStateSize)
+	}
+	if w.shared == nil {
+		w.shared = flock.NewFlock(w.cfg.lockName())
+	}
+	if err := w.shared.Lock(); err != nil {
+		return errorf("%s lock \"%s\" error: %v", w._Name_, w.cfg.lockName(), err)
+	}
+	return nil
+}
+
+//共享模式首次打开活动文件, 持有锁文件期间执行(已持有w.mu)
+func (w *FileWrite) initShared() error {
+	if err := w.cfg.checkShared(); err != nil {
+		return err
+	}
+	if err := w.lockShared(); err != nil {
+		return err
+	}
+	defer w.shared.Unlock()
+
+	if err := w.fileRotate(w.cfger.GetFileEof()); err != nil {
+		return err
+	}
+	return w.saveShared()
+}
+
+//同步其它进程写入后的活动文件状态(已持有w.mu和锁文件)
+//	活动文件被其它进程重命名时重新打开, 尺寸与锁文件状态一致时直接使用其行数,
+//	否则重新统计行数
+func (w *FileWrite) syncShared() error {
+	st := loadShared(w.sharedFd, w.sharedBuf)
+	fileName := w.cfg.FileName
+	if st.File != "" {
+		fileName = st.File
+	}
+
+	size, reopen, err := w.muwt.reopenShared(fileName)
+	if err != nil {
+		return err
+	}
+	switch {
+	case st.File == fileName && st.Size == size:
+		w.cfg.CurSize, w.cfg.CurLines = size, st.Lines
+		if st.Day > 0 {
+			w.cfg.CurDay = st.Day
+		}
+	case reopen || size != w.cfg.CurSize:
+		w.cfg.CurSize = size
+		if err = w.rotateInit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//共享模式写入(已持有w.mu), 持有锁文件期间同步状态、检查旋转、追加写入并保存状态
+//bufs      	输入记录数据
+//lines     	输入记录行数
+//fit       	输入是否要求内容完整写入当前文件
+//fileName  	输出文件名
+//lineNo    	输出首行行号
+//offset    	输出记录偏移
+//err       	输出错误信息
+func (w *FileWrite) writeShared(bufs [][]byte, lines int64, fit bool) (
+	fileName string, lineNo, offset int64, err error) {
+	size := 0
+	for _, b := range bufs {
+		size += len(b)
+	}
+
+	if err = w.lockShared(); err != nil {
+		return "", 0, 0, err
+	}
+	defer w.shared.Unlock()
+
+	if err = w.syncShared(); err != nil {
+		return "", 0, 0, err
+	}
+	fileName, lineNo, rotateErr := w.rotateLocked(size, lines, int64(len(bufs)), fit)
+	offset = w.cfg.CurOffset
+	if len(bufs) == 1 {
+		_, err = w.muwt.Write(bufs[0])
+	} else {
+		_, err = w.muwt.Writev(bufs)
+	}
+	if e := w.saveShared(); e != nil {
+		w.log(LevelError, "save shared state", w.cfg.lockName(), e)
+	}
+	if rotateErr != nil {
+		err = rotateErr
+	}
+	return
+}
+
+//共享模式执行文件旋转, 持有锁文件期间执行
+func (w *FileWrite) rotateShared() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.lockShared(); err != nil {
+		return err
+	}
+	defer w.shared.Unlock()
+
+	if err := w.syncShared(); err != nil {
+		return err
+	}
+	if err := w.rotate(RotateManual); err != nil {
+		return err
+	}
+	return w.saveShared()
+}
+
+//共享模式检查活动文件(Go程安全), 已被其它进程重命名(inode变化)时关闭旧文件并重新打开
+//fileName	输入活动文件名
+//size    	输出活动文件尺寸
+//reopen  	输出是否重新打开
+//err     	输出错误信息
+func (mw *MutexWrite) reopenShared(fileName string) (size int64, reopen bool, err error) {
+	if mw == nil {
+		return 0, false, ErrFileNil
+	}
+
+	isFileSync := mw.cfger.IsFileSync()
+	files := mw.cfger.GetRegistry()
+
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
+	if mw.closed || mw.file == nil || mw.file == os.Stdout {
+		return 0, false, ErrFileClosed
+	}
+
+	cur, err := mw.file.Stat()
+	if err != nil {
+		return 0, false, err
+	}
+	if stat, e := os.Stat(fileName); e == nil && os.SameFile(stat, cur) &&
+		mw.file.Name() == fileName {
+		return cur.Size(), false, nil
+	}
+
+	//以append方式打开新的活动文件，不存在则创建
+	fd, err := openFileWithCreateAppend(fileName, isFileSync)
+	if err != nil {
+		return 0, false, err
+	}
+	fs, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return 0, false, err
+	}
+
+	curName := mw.file.Name()
+	files.Release(mw.file)
+	if e := mw.file.Close(); e != nil {
+		mw.log(LevelError, "close", curName, e)
+	}
+	mw.file = fd
+	files.Acquire(mw.file)
+
+	size, headLines := fs.Size(), int64(0)
+	mw.resetStats(size)
+	if size == 0 { //新文件写入文件头
+		size, headLines = mw.writeHeader(fileName, curName)
+	}
+	mw.cfger.setCurFileName(fileName, size)
+	mw.cfger.setCurHeader(headLines)
+	mw.openEvent(fileName, size)
+	mw.log(LevelTrace, "reopen", fileName, nil)
+	return size, true, nil
+}
+
+//共享模式关闭锁文件状态读写句柄
+func (w *FileWrite) closeSharedState() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.sharedFd != nil {
+		w.sharedFd.Close()
+		w.sharedFd = nil
+	}
+}
+
+//共享模式关闭文件(Go程安全), 不写文件结束填充也不重命名, 活动文件由其它进程继续写入
+func (mw *MutexWrite) closeShared() error {
+	if mw == nil {
+		return ErrFileNil
+	}
+
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
+	if mw.stdout || mw.file == os.Stdout {
+		return nil
+	}
+	if mw.closed {
+		return ErrFileClosed
+	}
+
+	err := mw.file.Close()
+	mw.cfger.GetRegistry().Release(mw.file)
+	mw.closed = true
+	return err
+}
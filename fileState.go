@@ -0,0 +1,162 @@
+// fileState
+package fwrite
+
+import (
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+const (
+	StateSuffix   = ".state" //状态文件后缀
+	stateTailSize = 4096     //尾部校验尺寸
+	stateMaxMarks = 256      //最大检查点数
+)
+
+//文件状态, 保存于活动文件旁的状态文件, 重启时避免重新扫描整个文件,
+//文件封存时随文件一起重命名
+type FileState struct {
+	Size   int64      `json:"size"`            //文件尺寸
+	Lines  int64      `json:"lines"`           //文件行数
+	Offset int64      `json:"offset"`          //最后记录偏移
+	Tail   uint32     `json:"tail"`            //文件尾部校验和
+	Head   int64      `json:"head,omitempty"`  //未计入行数的文件头行数
+	Marks  []FileMark `json:"marks,omitempty"` //行号偏移检查点
+
+	FirstSeq uint64 `json:"firstSeq,omitempty"` //首个全局序号
+	SeqLine  int64  `json:"seqLine,omitempty"`  //首个全局序号所在行号
+	LastSeq  uint64 `json:"lastSeq,omitempty"`  //最后全局序号
+}
+
+//行号偏移检查点, 前Line行结束于Offset
+type FileMark struct {
+	Line   int64 `json:"line"`   //行数
+	Offset int64 `json:"offset"` //偏移
+}
+
+//添加检查点, 超过最大数量时隔点删除
+//marks	输入检查点列表
+//mark 	输入新检查点
+func addFileMark(marks []FileMark, mark FileMark) []FileMark {
+	if l := len(marks); l > 0 && marks[l-1].Line >= mark.Line {
+		return marks
+	}
+	if len(marks) >= stateMaxMarks {
+		half := marks[:0]
+		for i := 1; i < len(marks); i += 2 {
+			half = append(half, marks[i])
+		}
+		marks = half
+	}
+	return append(marks, mark)
+}
+
+//查找行号之前最近的检查点
+//marks 	输入检查点列表
+//lineNo	输入行号
+func findFileMark(marks []FileMark, lineNo int64) (mark FileMark) {
+	for _, m := range marks {
+		if m.Line >= lineNo {
+			break
+		}
+		mark = m
+	}
+	return
+}
+
+//计算文件尾部校验和
+//fileName	输入文件名
+//size    	输入文件尺寸
+func fileTailSum(fileName string, size int64) (uint32, error) {
+	fd, err := os.Open(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+
+	n := int64(stateTailSize)
+	if size < n {
+		n = size
+	}
+	buf := make([]byte, n)
+	if _, err = fd.ReadAt(buf, size-n); err != nil && err != io.EOF {
+		return 0, err
+	}
+	return crc32.ChecksumIEEE(buf), nil
+}
+
+//读取状态文件
+//fileName	输入数据文件名
+func loadFileState(fileName string) (*FileState, error) {
+	data, err := os.ReadFile(fileName + StateSuffix)
+	if err != nil {
+		return nil, err
+	}
+	st := new(FileState)
+	if err = json.Unmarshal(data, st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+//保存状态文件, 先写临时文件并刷盘再重命名
+//fileName	输入数据文件名
+//st      	输入文件状态
+func saveFileState(fileName string, st *FileState) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return replaceFile(fileName+StateSuffix, data)
+}
+
+//校验状态文件是否与数据文件一致
+//fileName	输入数据文件名
+//size    	输入数据文件尺寸
+func matchFileState(fileName string, size int64) (*FileState, bool) {
+	st, err := loadFileState(fileName)
+	if err != nil || st.Size != size {
+		return nil, false
+	}
+	if tail, err := fileTailSum(fileName, size); err != nil || tail != st.Tail {
+		return nil, false
+	}
+	return st, true
+}
+
+//保存当前文件状态(已持有锁)
+func (w *FileWrite) saveState() error {
+	if (!w.cfg.StateFile && !w.cfg.SeqMode) || w.muwt.IsStdout() || w.cfg.FileName == "" {
+		return nil
+	}
+
+	size, err := w.muwt.FileSize()
+	if err != nil {
+		return err
+	}
+	//尚有未完成写入, 本次不保存
+	if size != w.cfg.CurSize {
+		return nil
+	}
+
+	tail, err := fileTailSum(w.cfg.FileName, w.cfg.CurSize)
+	if err != nil {
+		return err
+	}
+	st := &FileState{
+		Size:   w.cfg.CurSize,
+		Lines:  w.cfg.CurLines,
+		Offset: w.cfg.CurOffset,
+		Tail:   tail,
+	}
+	if !w.cfg.HeaderLines {
+		st.Head = w.cfg.HeadLines
+	}
+	if w.cfg.SeqMode {
+		st.FirstSeq, st.SeqLine, st.LastSeq = w.cfg.FileSeq, w.cfg.SeqLine, w.cfg.CurSeq
+	}
+	w.marks = addFileMark(w.marks, FileMark{Line: st.Lines, Offset: st.Size})
+	st.Marks = w.marks
+	return saveFileState(w.cfg.FileName, st)
+}
@@ -0,0 +1,217 @@
+// fileStats
+package fwrite
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"io"
+	"os"
+	"time"
+)
+
+//文件统计信息
+type FileStats struct {
+	Name      string    //记录器名称
+	FileName  string    //当前文件名
+	NextName  string    //后续记录写入的文件名: 旋转时为活动文件, SetFD时为新文件, 关闭时为空
+	Records   int64     //记录数
+	Bytes     int64     //字节数
+	FirstTime time.Time //首条记录时间
+	LastTime  time.Time //末条记录时间
+	Sha256    []byte    //文件内容SHA-256
+	FirstSeq  uint64    //首个全局序号, 未分配为0
+	LastSeq   uint64    //最后全局序号
+	Chain     string    //最后链哈希, 未启用为空
+}
+
+//文件结束生成函数
+type TrailerFunc func(stats *FileStats) []byte
+
+//设置文件结束生成函数
+func (c *FileConfig) SetTrailerFunc(trailerFunc TrailerFunc) {
+	c.TrailerFunc = trailerFunc
+}
+
+//是否统计文件信息
+func (c *FileConfig) IsFileStats() bool {
+	return c.TrailerFunc != nil || c.Manifest
+}
+
+//是否生成文件结束记录
+func (c *FileConfig) IsFileTrailer() bool {
+	return c.TrailerFunc != nil
+}
+
+//获取文件结束填充, 优先使用生成函数
+func (c *FileConfig) GetFileTrailer(stats *FileStats) []byte {
+	if c.TrailerFunc == nil {
+		return c.FileEof
+	}
+	stats.Name = c.Name
+	if c.SeqMode {
+		stats.FirstSeq, stats.LastSeq = c.FileSeq, c.CurSeq
+	}
+	return c.TrailerFunc(stats)
+}
+
+//文件运行统计
+type fileStats struct {
+	on      bool      //是否统计
+	records int64     //记录数
+	bytes   int64     //字节数
+	first   time.Time //首条记录时间
+	last    time.Time //末条记录时间
+	hash    hash.Hash //内容摘要
+
+	frame bool      //是否二进制帧模式
+	file  hash.Hash //文件摘要, 含文件头、链记录和文件结束填充, 加密文件为密文摘要
+	size  int64     //文件尺寸
+	lines int64     //文件明文行数或帧数
+}
+
+func (s *fileStats) reset(on, frame bool) {
+	*s = fileStats{on: on, frame: frame}
+	if on {
+		s.hash = sha256.New()
+		s.file = sha256.New()
+	}
+}
+
+func (s *fileStats) addTime(records int64) {
+	if records > 0 {
+		now := time.Now()
+		if s.records == 0 {
+			s.first = now
+		}
+		s.last = now
+		s.records += records
+	}
+}
+
+func (s *fileStats) add(b []byte, records int64) {
+	if s.on {
+		s.bytes += int64(len(b))
+		s.hash.Write(b)
+		s.addTime(records)
+	}
+}
+
+func (s *fileStats) addString(str string, records int64) {
+	if s.on {
+		s.bytes += int64(len(str))
+		io.WriteString(s.hash, str)
+		s.addTime(records)
+	}
+}
+
+//记录写入文件的数据, 用于封存时生成校验清单
+//plain	输入明文
+//data 	输入写入文件的数据, 加密时为密文
+func (s *fileStats) addFile(plain, data []byte) {
+	if !s.on {
+		return
+	}
+	s.file.Write(data)
+	s.size += int64(len(data))
+	if !s.frame {
+		s.lines += int64(bytes.Count(plain, lineSep))
+		return
+	}
+	for len(plain) >= frameHead {
+		size := frameHead + int(binary.BigEndian.Uint32(plain[:4]))
+		if size > len(plain) {
+			break
+		}
+		plain = plain[size:]
+		s.lines++
+	}
+}
+
+//重置文件统计(已持有锁), 重新打开的非空文件统计已有内容
+//fileSize	输入当前文件尺寸
+func (mw *MutexWrite) resetStats(fileSize int64) {
+	frameMode := mw.cfger.IsFrameMode()
+	mw.stats.reset(mw.cfger.IsFileStats(), frameMode)
+	if !mw.stats.on {
+		return
+	}
+
+	//文件已有内容(如加密文件头)计入文件摘要
+	fileName := mw.file.Name()
+	if raw, err := os.Open(fileName); err == nil {
+		mw.stats.size, _ = io.Copy(mw.stats.file, raw)
+		raw.Close()
+	}
+	if fileSize == 0 {
+		return
+	}
+
+	fd, err := openPlain(fileName, mw.cfger.GetKeyProvider())
+	if err != nil {
+		mw.log(LevelError, "stats", mw.file.Name(), err)
+		return
+	}
+	defer fd.Close()
+
+	buf := make([]byte, 32768) // 32k
+	for {
+		n, err := fd.Read(buf)
+		mw.stats.bytes += int64(n)
+		if !frameMode {
+			mw.stats.records += int64(bytes.Count(buf[:n], lineSep))
+		}
+		mw.stats.hash.Write(buf[:n])
+		if err != nil {
+			break
+		}
+	}
+	if frameMode {
+		if rc, err := openPlain(fileName, mw.cfger.GetKeyProvider()); err == nil {
+			mw.stats.records, _, _ = walkFrames(rc, nil)
+			rc.Close()
+		}
+	}
+	mw.stats.lines = mw.stats.records
+	if stat, err := os.Stat(fileName); err == nil {
+		mw.stats.first, mw.stats.last = stat.ModTime(), stat.ModTime()
+	}
+}
+
+//生成文件结束填充(已持有锁), 未启用统计时返回nil
+//nextName	输入下一文件名
+func (mw *MutexWrite) fileTrailer(nextName string) []byte {
+	stats := mw.statsSnapshot(nextName)
+	if stats == nil {
+		return nil
+	}
+	return mw.cfger.GetFileTrailer(stats)
+}
+
+//封存前写入文件结束内容(已持有锁)
+//	生成的文件结束记录计入哈希链后再写入封存链记录, 改写文件结束记录会使校验失败;
+//	静态文件结束填充写在封存链记录之后, 校验时按内容比对
+//nextName 	输入下一文件名
+//fileEof  	输入静态文件结束填充
+//sealStats	输出封存文件统计, 未启用为nil
+func (mw *MutexWrite) writeFileEnd(nextName string, fileEof []byte) (sealStats *FileStats) {
+	if mw.cfger.IsFileTrailer() {
+		sealStats = mw.statsSnapshot(nextName)
+		if trailer := mw.fileTrailer(nextName); len(trailer) > 0 && !mw.closed {
+			trailer = mw.frameBytes(trailer)
+			n, _ := mw.writeFile(trailer)
+			mw.chain.add(trailer[:n])
+		}
+		mw.writeChain()
+	} else {
+		mw.writeChain()
+		sealStats = mw.statsSnapshot(nextName)
+		fileEof = mw.frameBytes(fileEof)
+		if !mw.closed && len(fileEof) > 0 {
+			mw.writeFile(fileEof)
+		}
+	}
+	mw.finishCrypt()
+	return
+}
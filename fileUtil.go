@@ -4,6 +4,9 @@ package fwrite
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"time"
 )
 
@@ -15,6 +18,7 @@ var (
 	ErrNameSame   = fmt.Errorf("file name is same or nil")
 	ErrNameEmpty  = fmt.Errorf("file name is empty or nil")
 	ErrFileSwitch = fmt.Errorf("file name switch fail")
+	ErrNewline    = fmt.Errorf("record contains newline")
 )
 
 const (
@@ -23,7 +27,7 @@ const (
 )
 
 var (
-	fLocks = &fileLock{files: make(map[string]*os.File)}
+	defaultRegistry = NewFileRegistry() //进程默认打开文件登记表
 )
 
 func logTime() string {
@@ -48,32 +52,42 @@ func FileExist(fileName string) bool {
 	}
 }
 
+//判斷文件是否锁定, 使用进程默认登记表
+func FileLocked(fileName string) bool {
+	return defaultRegistry.FileLocked(fileName)
+}
+
 //判斷文件是否锁定
 //	Lstat写法存读不到信息的BUG, 使用OpenFile来判断
-func FileLocked(fileName string) bool {
+func (f *FileRegistry) FileLocked(fileName string) bool {
 	//本写法不能准确判断文件是否存在
 	stat, err := os.Lstat(fileName)
 	if stat != nil && err == nil {
-		return fLocks.Exists(fileName)
+		return f.Exists(fileName)
 	}
 
 	//补救措施: 采用打开文件方式判断
 	if file, e := os.OpenFile(fileName, os.O_RDONLY, 0666); e == nil {
 		file.Close()
-		return fLocks.Exists(fileName)
+		return f.Exists(fileName)
 	} else if os.IsExist(e) {
-		return fLocks.Exists(fileName)
+		return f.Exists(fileName)
 	} else {
 		return false
 	}
 }
 
-//获取文件信息和是否存在
+//获取文件信息和是否存在, 使用进程默认登记表
 func FileInfo(fileName string) (stat os.FileInfo, exist, locked bool, err error) {
+	return defaultRegistry.FileInfo(fileName)
+}
+
+//获取文件信息和是否存在
+func (f *FileRegistry) FileInfo(fileName string) (stat os.FileInfo, exist, locked bool, err error) {
 	//本写法不能准确判断文件是否存在
 	stat, err = os.Lstat(fileName)
 	if stat != nil && err == nil {
-		return stat, true, fLocks.Exists(fileName), err
+		return stat, true, f.Exists(fileName), err
 	}
 
 	//补救措施: 采用打开文件方式判断
@@ -87,10 +101,9 @@ func FileInfo(fileName string) (stat os.FileInfo, exist, locked bool, err error)
 		}
 		file.Close()
 		if stat != nil {
-			return stat, true, fLocks.Exists(fileName), err
+			return stat, true, f.Exists(fileName), err
 		} else {
-			printf("<ERROR>[%s] FileInfo \"%v\" By os.OpenFile() Error: %v\n\n",
-				logTime(), fileName, err)
+			logEvent(DefaultLogger(), LevelError, "", "file info by open", fileName, err)
 		}
 	}
 
@@ -102,13 +115,32 @@ func FileInfo(fileName string) (stat os.FileInfo, exist, locked bool, err error)
 			tryTimes++
 		}
 		if stat != nil {
-			return stat, true, fLocks.Exists(fileName), err
+			return stat, true, f.Exists(fileName), err
 		} else {
-			printf("<ERROR>[%s] FileInfo \"%v\" By os.IsExist() Error: %v\n\n",
-				logTime(), fileName, err)
+			logEvent(DefaultLogger(), LevelError, "", "file info by lstat", fileName, err)
 		}
 	}
-	return nil, false, fLocks.Exists(fileName), ErrFileMiss
+	return nil, false, f.Exists(fileName), ErrFileMiss
+}
+
+//整理文件前缀, 去除结尾的'.'
+func trimPrefix(s string) string {
+	s = strings.TrimSpace(s)
+	if l := len(s); l > 0 && s[l-1] == '.' {
+		return s[:l-1]
+	} else {
+		return s
+	}
+}
+
+//整理文件后缀, 补齐开头的'.'
+func trimSuffix(s string) string {
+	s = strings.TrimSpace(s)
+	if l := len(s); l > 0 && s[0] != '.' {
+		return "." + s
+	} else {
+		return s
+	}
 }
 
 //以WriteOnly和Append打开文件，不存在则创建
@@ -121,8 +153,46 @@ func openFileWithCreateAppend(fileName string, fielSync bool) (*os.File, error)
 	return fd, err
 }
 
-func printf(format string, args ...interface{}) {
-	fmt.Fprintf(output(), format, args...)
+//原子替换文件, 先写临时文件并刷盘, 重命名后刷盘目录, 掉电后不会留下空文件
+//fileName	输入文件名
+//data    	输入文件内容
+func replaceFile(fileName string, data []byte) error {
+	tmpName := fileName + ".tmp"
+	fd, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+	if err != nil {
+		return err
+	}
+	if _, err = fd.Write(data); err == nil {
+		err = fd.Sync()
+	}
+	if e := fd.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err = os.Rename(tmpName, fileName); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(fileName))
+}
+
+//刷盘目录, 使重命名持久化, Windows不支持目录刷盘
+//dir	输入目录
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	fd, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = fd.Sync()
+	if e := fd.Close(); err == nil {
+		err = e
+	}
+	return err
 }
 
 func sprintf(format string, args ...interface{}) string {
@@ -1,12 +1,15 @@
 package fwrite
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	flock "github.com/yireyun/go-flock"
 )
 
 const (
@@ -51,6 +54,27 @@ type FileWrite struct {
 	//锁清理状态
 	lockCleaning int32
 
+	//当前文件行号偏移检查点
+	marks []FileMark
+
+	//多进程共享模式锁文件
+	shared flock.Flocker
+
+	//多进程共享模式锁文件状态读写句柄
+	sharedFd *os.File
+
+	//多进程共享模式锁文件状态读取缓存
+	sharedBuf []byte
+
+	//独占模式锁文件
+	owner flock.Flocker
+
+	//独占模式锁文件持有者
+	ownerInfo lockOwner
+
+	//锁文件持有者刷新停止通道
+	refresh chan struct{}
+
 	mu sync.Mutex
 }
 
@@ -131,36 +155,19 @@ func (w *FileWrite) Init(fileSync bool, filePrefix string,
 	rotate, dayend, fileZip, zeroSize bool, maxLines, maxSize int64,
 	cleaning bool, maxDays int) (string, error) {
 
-	prefix := func(s string) string {
-		s = strings.TrimSpace(s)
-		if l := len(s); l > 0 && s[l-1] == '.' {
-			return s[:l-1]
-		} else {
-			return s
-		}
-	}
-
-	suffix := func(s string) string {
-		s = strings.TrimSpace(s)
-		if l := len(s); l > 0 && s[0] != '.' {
-			return "." + s
-		} else {
-			return s
-		}
-	}
-	filePrefix = prefix(filePrefix)
+	filePrefix = trimPrefix(filePrefix)
 	if filePrefix == "" {
 		return "", errorf("filePrefix is null")
 	}
-	writeSuffix = suffix(writeSuffix)
+	writeSuffix = trimSuffix(writeSuffix)
 	if writeSuffix == "" {
 		return "", errorf("writeSuffix is null")
 	}
-	renameSuffix = suffix(renameSuffix)
+	renameSuffix = trimSuffix(renameSuffix)
 	if renameSuffix == "" {
 		return "", errorf("renameSuffix is null")
 	}
-	cleanSuffix = suffix(cleanSuffix)
+	cleanSuffix = trimSuffix(cleanSuffix)
 	if cleanSuffix == "" {
 		return "", errorf("cleanSuffix is null")
 	}
@@ -229,11 +236,20 @@ func (w *FileWrite) Init(fileSync bool, filePrefix string,
 	w.cfg.MaxDays = maxDays
 
 	if w.muwt.IsStdout() { //首次初始化
-		err := w.fileRotate(w.cfger.GetFileEof())
+		var err error
+		switch {
+		case w.cfg.SharedMode: //共享模式持有锁文件打开
+			err = w.initShared()
+		case w.cfg.LockStrict: //独占模式锁定后打开
+			err = w.initOwner()
+		default:
+			err = w.fileRotate(w.cfger.GetFileEof())
+		}
 		if err != nil {
 			w.cfg.FileName = ""
 			return w.cfg.FileName, err
 		}
+		w.startRefresh()
 		go w.lockClean(w.cfg.FileName)
 	}
 	return w.cfg.FileName, nil
@@ -246,43 +262,223 @@ func (w *FileWrite) SetFileZip(fileZip bool) {
 	w.mu.Unlock()
 }
 
+//是否保存状态文件
+func (w *FileWrite) SetStateFile(stateFile bool) {
+	w.mu.Lock()
+	w.cfg.StateFile = stateFile
+	w.mu.Unlock()
+}
+
+//设置索引间隔行数, 0为不建索引
+func (w *FileWrite) SetIndexEvery(indexEvery int64) {
+	w.mu.Lock()
+	w.cfg.IndexEvery = indexEvery
+	w.mu.Unlock()
+}
+
+//设置保留策略, 可在运行时修改, 下次清理时生效
+//cleaning  	输入是否清理历史
+//maxDays   	输入最大天数,最小为3天
+//maxAgeDays	输入强制清理天数,不等待消费者,0为不强制
+func (w *FileWrite) SetRetention(cleaning bool, maxDays, maxAgeDays int) error {
+	if err := checkRetention(cleaning, maxDays, maxAgeDays); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	w.cfg.Cleaning = cleaning
+	w.cfg.MaxDays = maxDays
+	w.cfg.MaxAgeDays = maxAgeDays
+	w.mu.Unlock()
+	return nil
+}
+
+//修改保留策略的部分项, 读取、校验和修改在同一次加锁内完成, 并发修改不会互相覆盖
+//cleaning  	输入是否清理历史, 为空不修改
+//maxDays   	输入最大天数,最小为3天, 为空不修改
+//maxAgeDays	输入强制清理天数,不等待消费者,0为不强制, 为空不修改
+func (w *FileWrite) UpdateRetention(cleaning *bool, maxDays, maxAgeDays *int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	clean, days, ageDays := w.cfg.Cleaning, w.cfg.MaxDays, w.cfg.MaxAgeDays
+	if cleaning != nil {
+		clean = *cleaning
+	}
+	if maxDays != nil {
+		days = *maxDays
+	}
+	if maxAgeDays != nil {
+		ageDays = *maxAgeDays
+	}
+	if err := checkRetention(clean, days, ageDays); err != nil {
+		return err
+	}
+	w.cfg.Cleaning, w.cfg.MaxDays, w.cfg.MaxAgeDays = clean, days, ageDays
+	return nil
+}
+
+//校验保留策略
+func checkRetention(cleaning bool, maxDays, maxAgeDays int) error {
+	if cleaning && maxDays < MaxKeepDays { //最小为3天
+		return errorf("maxDays not less than 3 day")
+	}
+	if maxAgeDays < 0 {
+		return errorf("maxAgeDays not less than 0")
+	}
+	return nil
+}
+
+//设置强制清理天数, 超过天数的文件不等待消费者处理, 0为不强制
+func (w *FileWrite) SetMaxAgeDays(maxAgeDays int) {
+	w.mu.Lock()
+	w.cfg.MaxAgeDays = maxAgeDays
+	w.mu.Unlock()
+}
+
+//是否二进制帧模式, 每条记录写为[长度][CRC32C][数据], 行号为帧序号
+func (w *FileWrite) SetFrameMode(frameMode bool) {
+	w.mu.Lock()
+	w.cfg.FrameMode = frameMode
+	w.mu.Unlock()
+}
+
+//设置尾部修复方式, 重新打开非空文件时修复崩溃造成的不完整记录
+//	行模式只在补齐换行或行计数时修复, 否则最后一行未以换行结尾也是完整记录
+//	帧模式封存时不完整数据按帧追加到.torn文件, 随文件一起重命名
+//tailRepair	输入修复方式: RepairNone, RepairTruncate, RepairSeal
+//tornMarker	输入封存标记行, 为空时使用DefaultTornMarker
+func (w *FileWrite) SetTailRepair(tailRepair int, tornMarker []byte) {
+	w.mu.Lock()
+	w.cfg.TailRepair = tailRepair
+	w.cfg.TornMarker = tornMarker
+	w.mu.Unlock()
+}
+
+//读取最后一次尾部修复报告, 未修复返回nil
+func (w *FileWrite) LastRepair() *RepairReport {
+	return w.muwt.LastRepair()
+}
+
+//设置文件头
+//header     	输入静态文件头
+//headerFunc 	输入文件头生成函数, 优先于静态文件头
+//headerLines	输入文件头是否计入行数
+func (w *FileWrite) SetFileHeader(header []byte, headerFunc HeaderFunc,
+	headerLines bool) {
+	w.mu.Lock()
+	w.cfg.FileHeader = header
+	w.cfg.HeaderFunc = headerFunc
+	w.cfg.HeaderLines = headerLines
+	w.mu.Unlock()
+}
+
+//设置文件结束填充
+//fileEof    	输入静态文件结束填充
+//trailerFunc	输入文件结束生成函数, 优先于静态文件结束填充
+func (w *FileWrite) SetFileTrailer(fileEof []byte, trailerFunc TrailerFunc) {
+	w.mu.Lock()
+	w.cfg.FileEof = fileEof
+	w.cfg.TrailerFunc = trailerFunc
+	w.mu.Unlock()
+}
+
+//设置行计数方式
+//count   	输入是否按换行符计算行数, 开启时每条记录至少一行, 始终补齐结尾换行符
+//appendNl	输入是否补齐结尾换行符
+//newline 	输入内嵌换行符处理方式
+func (w *FileWrite) SetLineMode(count, appendNl bool, newline int) {
+	w.mu.Lock()
+	w.cfg.LineCount = count
+	w.cfg.LineAppend = appendNl
+	w.cfg.LineNewline = newline
+	w.mu.Unlock()
+}
+
 //文件旋转
 func (w *FileWrite) fileRotate(fileEof []byte) (err error) {
 
+	//保存旧文件状态, 随文件封存
+	w.saveState()
+
 	//互斥记录器切换文件
 	err = w.muwt.SwitchFD()
 
+	w.marks = nil
 	w.rotateInit()
+	w.rotateSeq()
+	w.cfg.ChainRecords = 0
+	if e := w.saveState(); e != nil {
+		w.log(LevelError, "save state", w.cfg.FileName, e)
+	}
 	return
 }
 
 //文件旋转检查
 //size     	输入写内容尺寸
+//lines    	输入写内容行数
 //fileName  输出文件名
 //lineNo    输出文件行号
-func (w *FileWrite) rotateCheck(size int) (fileName string, lineNo int64) {
+func (w *FileWrite) rotateCheck(size int, lines int64) (fileName string, lineNo int64) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	now := time.Now()
-	if w.cfg.Rotate && !w.muwt.IsStdout() && //未执行初始化,不切文件
-		((w.cfg.MaxLines > 0 && w.cfg.CurLines >= w.cfg.MaxLines) || //最大行数触发切文件
-			(w.cfg.MaxSize > 0 && w.cfg.CurSize >= w.cfg.MaxSize) || //最大尺寸触发切文件
-			(w.cfg.Dayend && now.Day() != w.cfg.CurDay)) { //日期变化触发切文件
-		if err := w.Rotate(); err != nil {
-			printf("<ERROR>[%s] %s rotate error：%v\n\n",
-				logTime(), w._Name_, err)
-			return
+	fileName, lineNo, _ = w.rotateLocked(size, lines, 1, false)
+	return
+}
+
+//文件旋转检查(已持有锁)
+//	旋转失败时内容仍写入当前文件并照常计数, 旋转错误在写入后返回给调用者
+//size     	输入写内容尺寸
+//lines    	输入写内容行数
+//records  	输入写内容记录数, 每条记录分配一个全局序号
+//fit       输入是否要求内容完整写入当前文件
+//fileName  输出文件名
+//lineNo    输出文件行号
+//err       输出旋转错误RotateError
+func (w *FileWrite) rotateLocked(size int, lines, records int64, fit bool) (
+	fileName string, lineNo int64, err error) {
+	if reason := w.rotateReason(size, lines, fit); reason != "" {
+		if err = w.rotate(reason); err != nil {
+			w.fail("rotate", w.cfg.FileName, err)
 		}
-		w.cfg.CurLines++
-		w.cfg.CurSize += int64(size)
-		return w.cfg.FileName, w.cfg.CurLines
-	} else {
-		w.cfg.CurLines++
-		w.cfg.CurSize += int64(size)
-		fileName, lineNo = w.cfg.FileName, w.cfg.CurLines
-		return
 	}
+
+	//行号为本记录首行所在行
+	fileName, lineNo = w.cfg.FileName, w.cfg.CurLines+1
+	w.cfg.CurOffset = w.cfg.CurSize
+	w.cfg.CurLines += lines
+	if w.cfg.SeqMode {
+		w.cfg.CurSeq += uint64(records)
+	}
+	w.cfg.CurSize += int64(size)
+	return
+}
+
+//文件旋转原因(已持有锁)
+//size     	输入写内容尺寸
+//lines    	输入写内容行数
+//fit       输入是否要求内容完整写入当前文件
+//reason    输出旋转原因, 不需要旋转为空
+func (w *FileWrite) rotateReason(size int, lines int64, fit bool) (reason string) {
+	if !w.cfg.Rotate || w.muwt.IsStdout() { //未执行初始化,不切文件
+		return ""
+	}
+	switch {
+	case w.cfg.MaxLines > 0 && w.cfg.CurLines >= w.cfg.MaxLines: //最大行数触发切文件
+		return RotateLines
+	case w.cfg.MaxSize > 0 && w.cfg.CurSize >= w.cfg.MaxSize: //最大尺寸触发切文件
+		return RotateSize
+	case w.cfg.Dayend && time.Now().Day() != w.cfg.CurDay: //日期变化触发切文件
+		return RotateDay
+	case fit && w.cfg.MaxLines > 0 && w.cfg.CurLines > 0 &&
+		w.cfg.CurLines+lines > w.cfg.MaxLines: //整批超出最大行数切文件
+		return RotateBatch
+	case fit && w.cfg.MaxSize > 0 && w.cfg.CurSize > 0 &&
+		w.cfg.CurSize+int64(size) > w.cfg.MaxSize: //整批超出最大尺寸切文件
+		return RotateBatch
+	}
+	return ""
 }
 
 //文件旋转初始化
@@ -290,15 +486,33 @@ func (w *FileWrite) rotateInit() error {
 
 	if !w.cfg.ZeroSize {
 		if w.cfg.CurSize > 0 {
+			//状态文件与数据文件一致, 则不扫描文件
+			if w.cfg.StateFile {
+				if st, ok := matchFileState(w.cfg.FileName, w.cfg.CurSize); ok {
+					w.cfg.CurLines = st.Lines
+					w.cfg.CurOffset = st.Offset
+					w.cfg.HeadLines = st.Head
+					w.marks = st.Marks
+					return nil
+				}
+			}
 			count, err := w.cfger.GetFileLines(w.cfg.FileName)
-			if err != nil {
+			if _, torn := err.(*FrameError); torn {
+				w.log(LevelError, "get file lines", w.cfg.FileName, err)
+			} else if err != nil {
 				return errorf("%s get file lines err: %v\n\n", w._Name_, err)
 			}
+			if !w.cfg.HeaderLines { //文件头不计入行数
+				w.cfg.HeadLines = w.cfg.fileHeadLines(w.cfg.FileName)
+				count -= w.cfg.HeadLines
+			}
 
 			w.cfg.CurLines = count
 		} else {
 			w.cfg.CurLines = 0
 		}
+	} else if w.cfg.HeaderLines {
+		w.cfg.CurLines = w.cfg.HeadLines
 	} else {
 		w.cfg.CurLines = 0
 	}
@@ -309,10 +523,75 @@ func (w *FileWrite) rotateInit() error {
 //in    		输入保存数据
 //fileName  	输出文件名
 //lineNo    	输出文件行号
-//err   	   	输出错误信息
+//err   	   	输出错误信息, 需要全局序号时使用WritePos
 func (w *FileWrite) Write(in []byte) (fileName string, lineNo int64, err error) {
-	fileName, lineNo = w.rotateCheck(len(in))
+	start, size := w.cfg.metrics.writeStart(), 0
+	defer func() { w.cfg.metrics.writeDone(start, 1, size, err) }()
+
+	fileName, lineNo, size, err = w.write(in)
+	return
+}
+
+//编码并写入数据, 编码、旋转检查和写入在同一次加锁内完成, 与WriteBatch一致
+//in    		输入保存数据
+//fileName  	输出文件名
+//lineNo    	输出文件行号
+//size      	输出编码后尺寸
+//err   	   	输出错误信息
+func (w *FileWrite) write(in []byte) (fileName string, lineNo int64, size int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	in, lines, err := w.cfg.record(in)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if w.cfg.SharedMode {
+		fileName, lineNo, _, err = w.writeShared([][]byte{in}, lines, false)
+		return fileName, lineNo, len(in), err
+	}
+	fileName, lineNo, rotateErr := w.rotateLocked(len(in), lines, 1, false)
+	chain := w.chainLocked(1)
 	_, err = w.muwt.Write(in)
+	w.writeChainLocked(chain, err)
+	if rotateErr != nil {
+		err = rotateErr
+	}
+	return fileName, lineNo, len(in), err
+}
+
+//写入数据, 返回记录位置
+//in    		输入保存数据
+//pos    		输出记录位置, 写入时间用于文件重命名后定位
+//err   	   	输出错误信息
+func (w *FileWrite) WritePos(in []byte) (pos Position, err error) {
+	start, lines := w.cfg.metrics.writeStart(), int64(0)
+	defer func() { w.cfg.metrics.writeDone(start, 1, len(in), err) }()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if in, lines, err = w.cfg.record(in); err != nil {
+		return pos, err
+	}
+	if w.cfg.SharedMode {
+		pos.FileName, pos.LineNo, pos.Offset, err = w.writeShared([][]byte{in}, lines, false)
+		pos.Time = time.Now()
+		return
+	}
+
+	var rotateErr error
+	pos.FileName, pos.LineNo, rotateErr = w.rotateLocked(len(in), lines, 1, false)
+	pos.Offset, pos.Time = w.cfg.CurOffset, time.Now()
+	if w.cfg.SeqMode {
+		pos.Seq = w.cfg.CurSeq
+	}
+	chain := w.chainLocked(1)
+	_, err = w.muwt.Write(in)
+	w.writeChainLocked(chain, err)
+	if rotateErr != nil {
+		err = rotateErr
+	}
 	return
 }
 
@@ -322,22 +601,113 @@ func (w *FileWrite) Write(in []byte) (fileName string, lineNo int64, err error)
 //lineNo    输出文件行号
 //err   	输出错误信息
 func (w *FileWrite) WriteString(s string) (fileName string, lineNo int64, err error) {
-	fileName, lineNo = w.rotateCheck(len(s))
+	start, size := w.cfg.metrics.writeStart(), len(s)
+	defer func() { w.cfg.metrics.writeDone(start, 1, size, err) }()
+
+	w.mu.Lock()
+	if w.cfg.LineCount || w.cfg.LineAppend || w.cfg.LineNewline != NewlineKeep ||
+		w.cfg.FrameMode || w.cfg.SharedMode || w.cfg.SeqMode {
+		w.mu.Unlock()
+		fileName, lineNo, size, err = w.write([]byte(s))
+		return
+	}
+	defer w.mu.Unlock()
+
+	fileName, lineNo, rotateErr := w.rotateLocked(len(s), 1, 1, false)
+	chain := w.chainLocked(1)
 	_, err = w.muwt.WriteString(s)
+	w.writeChainLocked(chain, err)
+	if rotateErr != nil {
+		err = rotateErr
+	}
+	return
+}
+
+//批量写入数据, 整批记录只在写入前检查一次旋转, 保证写入同一文件且连续
+//ins    		输入批量记录数据
+//fileName  	输出文件名
+//firstNo   	输出首行行号
+//lastNo    	输出末行行号
+//err   	   	输出错误信息
+func (w *FileWrite) WriteBatch(ins [][]byte) (fileName string,
+	firstNo, lastNo int64, err error) {
+	if len(ins) == 0 {
+		return w.cfg.FileName, 0, 0, nil
+	}
+
+	start, size := w.cfg.metrics.writeStart(), 0
+	defer func() { w.cfg.metrics.writeDone(start, len(ins), size, err) }()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	bufs := make([][]byte, len(ins))
+	lines := int64(0)
+	for i, in := range ins {
+		out, n, e := w.cfg.record(in)
+		if e != nil {
+			return "", 0, 0, e
+		}
+		bufs[i] = out
+		size += len(out)
+		lines += n
+	}
+	if w.cfg.SharedMode {
+		fileName, firstNo, _, err = w.writeShared(bufs, lines, true)
+		if lastNo = firstNo; lines > 1 {
+			lastNo = firstNo + lines - 1
+		}
+		return
+	}
+
+	fileName, firstNo, rotateErr := w.rotateLocked(size, lines, int64(len(ins)), true)
+	if lastNo = firstNo; lines > 1 {
+		lastNo = firstNo + lines - 1
+	}
+	chain := w.chainLocked(int64(len(ins)))
+	_, err = w.muwt.Writev(bufs)
+	w.writeChainLocked(chain, err)
+	if rotateErr != nil {
+		err = rotateErr
+	}
 	return
 }
 
 //执行文件旋转
 func (w *FileWrite) Rotate() error {
+	if w.cfg.SharedMode && !w.muwt.IsStdout() { //共享模式持有锁文件旋转
+		return w.rotateShared()
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rotate(RotateManual)
+}
+
+//执行文件旋转(已持有锁, 共享模式已持有锁文件), 旋转前后触发旋转事件
+//reason	输入旋转原因
+func (w *FileWrite) rotate(reason string) error {
+	fileName := w.cfg.FileName
+	ev := Event{Type: EventRotateStart, Name: w._Name_, FileName: fileName,
+		Reason: reason, Size: w.cfg.CurSize, Lines: w.cfg.CurLines}
+	w.cfger.fireEvent(ev)
+
 	err := w.fileRotate(w.cfger.GetFileEof())
-	if err != nil { //文件旋转错
-		return err
+	if err != nil && !errors.As(err, new(*RenameError)) { //文件旋转错
+		return &RotateError{FileName: fileName, Err: err}
 	}
 
+	//旧文件重命名失败时已切换到新文件, 照常触发事件后返回错误
+	ev.Type, ev.NewName, ev.Time = EventRotate, w.cfg.FileName, time.Time{}
+	ev.Sealed, ev.Stats = w.muwt.takeSealed()
+	w.cfger.fireEvent(ev)
+
 	if w.cfg.Cleaning { //执行文件清理
-		go w.fileClean(w.cfg.FileName)
+		go w.fileClean(w.cfg.FileName, false)
 	}
 
+	if err != nil {
+		return &RotateError{FileName: fileName, Err: err}
+	}
 	return nil
 }
 
@@ -346,10 +716,26 @@ func (w *FileWrite) FileClean() (error, []string) {
 	if !FileExist(w.cfg.FileName) { //文件不存在
 		return ErrFileMiss, nil
 	}
-	return w.fileClean(w.cfg.FileName)
+	return w.fileClean(w.cfg.FileName, false)
 }
 
-func (w *FileWrite) fileClean(fileName string) (error, []string) {
+//文件清理预演, 只列出将被删除的文件, 不删除和重命名
+func (w *FileWrite) FileCleanDryRun() (error, []string) {
+	if !FileExist(w.cfg.FileName) { //文件不存在
+		return ErrFileMiss, nil
+	}
+	return w.fileClean(w.cfg.FileName, true)
+}
+
+//文件清理
+//fileName	输入当前文件名
+//dryRun  	输入是否预演, 预演时只列出将被删除的文件
+func (w *FileWrite) fileClean(fileName string, dryRun bool) (error, []string) {
+	//保留策略可在运行时修改
+	w.mu.Lock()
+	maxDays, maxAgeDays := w.cfg.MaxDays, w.cfg.MaxAgeDays
+	w.mu.Unlock()
+
 	dir := filepath.Dir(fileName)
 	absPath, err := filepath.Abs(dir)
 	if err != nil {
@@ -388,12 +774,19 @@ func (w *FileWrite) fileClean(fileName string) (error, []string) {
 		curCleanSuffix = curCleanSuffix + zipFileSuffix
 	}
 
+	//记录首个清理错误, 清理完成后返回
+	var cleanErr error
+	fail := func(op, path string, err error) {
+		if err = w.fail(op, path, err); cleanErr == nil {
+			cleanErr = err
+		}
+	}
+
 	//遍历目录函数函数
 	cleanFunc := func(path string, info os.FileInfo, err error) (retErr error) {
 		defer func() {
 			if r := recover(); r != nil {
-				printf("<ERROR>[%s] %s clean \"%s\" panic:%v\n\n",
-					logTime(), w._Name_, path, r)
+				fail("clean", path, &CleanError{Path: path, Op: "clean", Err: errorf("panic: %v", r)})
 			}
 		}()
 
@@ -410,11 +803,13 @@ func (w *FileWrite) fileClean(fileName string) (error, []string) {
 			dirPrefix := filepath.Dir(w.cfg.FilePrefix)   //获取FilePrefix的目录
 			toDay := truncToDay(info.ModTime())           //文件时间到凌晨0点时间
 
-			if dirPath == dirPrefix && toDay < yesterday &&
+			if dirPath == dirPrefix &&
 				strings.HasPrefix(basePath, basePrefix) &&
-				!strings.HasSuffix(basePath, fileName+LockSuffix) &&
 				strings.HasSuffix(basePath, w.cfg.WriteSuffix+LockSuffix) {
-				os.Remove(basePath) //删除过去无效的锁文件
+				if !dryRun && basePath != filepath.Base(fileName)+LockSuffix &&
+					lockStale(path, toDay < yesterday) {
+					os.Remove(path) //删除持有者已失效的锁文件
+				}
 				return
 			}
 
@@ -441,12 +836,11 @@ func (w *FileWrite) fileClean(fileName string) (error, []string) {
 	//遍类目录线的所有文件
 	err = filepath.Walk(dir, cleanFunc)
 	if err != nil {
-		printf("<ERROR>[%v] %s over walk error: %v\n\n",
-			logTime(), w._Name_, err)
+		fail("walk", absPath, &CleanError{Path: absPath, Op: "walk", Err: err})
 	}
 
 	//结算Keep保持时间
-	keepDays := w.cfg.MaxDays
+	keepDays := maxDays
 	if keepDays < MaxKeepDays {
 		keepDays = MaxKeepDays
 	}
@@ -458,44 +852,72 @@ func (w *FileWrite) fileClean(fileName string) (error, []string) {
 	//取绝对失效时间
 	abcTime := yesterday - 60*60*24*int64(keepDays)
 
+	//消费者处理完成后才能删除, 超过强制清理天数除外
+	consumers := NewConsumers(w.cfg)
+	offsets, loadErr := consumers.Offsets()
+	if loadErr != nil {
+		w.log(LevelError, "load consumers", w.cfg.FileName, loadErr)
+	}
+	maxAgeTime := yesterday - 60*60*24*int64(maxAgeDays)
+	consumed := func(f *file) bool {
+		if loadErr != nil {
+			return false
+		}
+		if maxAgeDays > 0 && f.Modfy.Unix() < maxAgeTime {
+			return true
+		}
+		return consumers.passed(offsets, f.Path)
+	}
+
 	cleanFile := make([]string, 0, len(files))
 	//对文件进行排序
 	for _, file := range files {
 		//删除过期的数据，至少保持最近3天的数据文件，增加结对时间判断防止误删除
 		if file.Modfy.Unix() < abcTime && file.Modfy.Unix() < keepTime &&
 			strings.HasSuffix(file.Path, curCleanSuffix) &&
-			!FileLocked(file.Path) {
+			!w.cfger.GetRegistry().FileLocked(file.Path) && consumed(file) {
+			if dryRun {
+				cleanFile = append(cleanFile, file.Path)
+				continue
+			}
 			err := os.Remove(file.Path)
 			if err != nil {
-				printf("<ERROR>[%s] %s os.Remove %v, err : %v\n\n",
-					logTime(), w._Name_, file.Path, err)
+				fail("remove", file.Path, &CleanError{Path: file.Path, Op: "remove", Err: err})
 			} else {
+				removeSidecars(file.Path)
 				cleanFile = append(cleanFile, file.Path)
+				w.cfger.fireEvent(Event{Type: EventClean, Name: w._Name_,
+					FileName: file.Path, Size: file.Size})
 			}
 			continue
 		}
 
 		//检查并更改名称
-		if w.cfg.CleanRename && w.cfg.CleanRenameSuffix &&
+		if !dryRun && w.cfg.CleanRename && w.cfg.CleanRenameSuffix &&
 			file.Modfy.Unix() < yesterday &&
 			strings.HasSuffix(file.Path, w.cfg.WriteSuffix) &&
-			!FileLocked(file.Base) {
+			!w.cfger.GetRegistry().FileLocked(file.Base) {
 			newName, err := w.cfger.GetFileRename(file.Base)
 			if err == nil {
 				err = os.Rename(file.Base, newName)
 				if err != nil {
-					printf("<ERROR>[%s] %s os.Rename %v -> %v , err : %v \n\n",
-						logTime(), w._Name_, file.Base, newName, err)
+					fail("rename", file.Base, &RenameError{From: file.Base, To: newName, Err: err})
 				} else if w.cfger.IsFileZip() {
-					go zipLogFile(newName)
+					go zipReport(w.cfger, w._Name_, newName)
 				}
 			} else {
-				printf("<ERROR>[%s] %s get rename %v -> %v , err : %v \n\n",
-					logTime(), w._Name_, file.Base, newName, err)
+				fail("get rename", file.Base, &RenameError{From: file.Base, Err: err})
 			}
 		}
 	}
-	return nil, cleanFile
+	if len(cleanFile) > 0 && !dryRun {
+		pruneNames(w.cfg.FilePrefix + w.cfg.WriteSuffix)
+		if err := removeManifest(cleanFile); err != nil {
+			fail("remove manifest", w.cfg.FileName,
+				&CleanError{Path: manifestPath(w.cfg.FileName), Op: "remove manifest", Err: err})
+		}
+	}
+	return cleanErr, cleanFile
 }
 
 func (w *FileWrite) lockClean(fileName string) error {
@@ -524,8 +946,8 @@ func (w *FileWrite) lockClean(fileName string) error {
 	cleanFunc := func(path string, info os.FileInfo, err error) (retErr error) {
 		defer func() {
 			if x := recover(); x != nil {
-				printf("<ERROR>[%s] %s clean \"%s\" panic :%v\n\n",
-					logTime(), w._Name_, path, x)
+				w.fail("clean lock", path,
+					&CleanError{Path: path, Op: "clean lock", Err: errorf("panic: %v", x)})
 			}
 		}()
 
@@ -541,11 +963,12 @@ func (w *FileWrite) lockClean(fileName string) error {
 			dirPath := filepath.Dir(path)                 //获取path的目录
 			dirPrefix := filepath.Dir(w.cfg.FilePrefix)   //获取FilePrefix的目录
 			toDay := truncToDay(info.ModTime())           //文件时间到凌晨0点时间
-			if dirPath == dirPrefix && toDay < yesterday &&
+			if dirPath == dirPrefix &&
 				strings.HasPrefix(basePath, basePrefix) &&
-				!strings.HasSuffix(basePath, fileName+LockSuffix) &&
-				strings.HasSuffix(basePath, w.cfg.WriteSuffix+LockSuffix) {
-				os.Remove(basePath) //删除过去无效的锁文件
+				basePath != filepath.Base(fileName)+LockSuffix &&
+				strings.HasSuffix(basePath, w.cfg.WriteSuffix+LockSuffix) &&
+				lockStale(path, toDay < yesterday) {
+				os.Remove(path) //删除持有者已失效的锁文件
 			}
 		}
 		return
@@ -554,23 +977,53 @@ func (w *FileWrite) lockClean(fileName string) error {
 	//遍类目录线的所有文件
 	err = filepath.Walk(dir, cleanFunc)
 	if err != nil {
-		printf("<ERROR>[%s] %s over walk error:%v\n\n",
-			logTime(), w._Name_, err)
+		return w.fail("walk", absPath, &CleanError{Path: absPath, Op: "walk", Err: err})
 	}
 	return nil
 }
 
 //释放所有资源
 func (w *FileWrite) Destroy() {
+	w.stopRefresh()
+	if w.cfg.SharedMode {
+		w.muwt.closeShared()
+		w.closeSharedState()
+		return
+	}
 	w.muwt.Close()
+	w.unlockOwner()
 }
 
 //释放所有资源
 func (w *FileWrite) Close() error {
-	return w.muwt.Close()
+	w.mu.Lock()
+	w.saveState()
+	shared := w.cfg.SharedMode
+	w.mu.Unlock()
+
+	w.stopRefresh()
+	if shared { //共享模式不封存活动文件
+		w.closeSharedState()
+		return w.muwt.closeShared()
+	}
+	err := w.muwt.Close()
+	w.unlockOwner()
+	return err
 }
 
 //写入缓存数据
 func (w *FileWrite) Flush() {
-	w.muwt.Flush()
+	if w.muwt.IsStdout() {
+		w.muwt.Flush()
+	} else {
+		start := time.Now()
+		w.muwt.Flush()
+		w.cfg.metrics.sync.observe(time.Since(start))
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.saveState(); err != nil {
+		w.log(LevelError, "save state", w.cfg.FileName, err)
+	}
 }
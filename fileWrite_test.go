@@ -1,10 +1,20 @@
 package fwrite
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
+	"io"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"runtime/pprof"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -92,3 +102,1174 @@ func BenchmarkWrite(b *testing.B) {
 		write.WriteString("")
 	}
 }
+
+func TestWriteLines(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "TestLines")
+	w := NewFileWrite("TestLines")
+	w.SetLineMode(true, true, NewlineKeep)
+	_, err := w.Init(false, prefix, "log", "log", "log",
+		true, false, false, false, 5, 0, false, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for i, c := range []struct {
+		in     string
+		lineNo int64
+	}{{"a\n", 1}, {"b\nc\n", 2}, {"d", 4}, {"e\nf", 5}} {
+		_, lineNo, err := w.WriteString(c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lineNo != c.lineNo {
+			t.Fatalf("%d: lineNo %d, want %d", i, lineNo, c.lineNo)
+		}
+	}
+
+	//行数达到上限后切换文件
+	fileName, lineNo, _ := w.WriteString("g\n")
+	if lineNo != 1 {
+		t.Fatalf("after rotate lineNo %d, want 1", lineNo)
+	}
+	w.Flush()
+	if n, _ := w.cfger.GetFileLines(fileName); n != 1 {
+		t.Fatalf("file lines %d, want 1", n)
+	}
+
+	w.SetLineMode(true, false, NewlineEscape)
+	if _, lineNo, _ = w.WriteString("h\ni\n"); lineNo != 2 {
+		t.Fatalf("escape lineNo %d, want 2", lineNo)
+	}
+	//原有的反斜杠先转义, 与转义的换行符可以区分
+	if _, lineNo, _ = w.WriteString(`x\n\`); lineNo != 3 {
+		t.Fatalf("escape lineNo %d, want 3", lineNo)
+	}
+	//行计数时未以换行结尾的记录也占一行
+	w.SetLineMode(true, false, NewlineKeep)
+	if _, lineNo, _ = w.WriteString("y"); lineNo != 4 {
+		t.Fatalf("unterminated lineNo %d, want 4", lineNo)
+	}
+	if fileName, lineNo, _ = w.WriteString("z\n"); lineNo != 5 {
+		t.Fatalf("after unterminated lineNo %d, want 5", lineNo)
+	}
+	w.Flush()
+	if data, _ := os.ReadFile(fileName); string(data) != "g\nh\\ni\n"+`x\\n\\`+"\ny\nz\n" {
+		t.Fatalf("line mode data %q", data)
+	}
+	w.SetLineMode(true, false, NewlineReject)
+	if _, _, err = w.WriteString("j\nk\n"); err != ErrNewline {
+		t.Fatalf("reject err %v, want %v", err, ErrNewline)
+	}
+
+	//写入期间切换行计数方式
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			w.SetLineMode(i%2 == 0, true, NewlineKeep)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		w.WriteString("l\n")
+	}
+	wg.Wait()
+}
+
+func TestWriteBatch(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "TestBatch")
+	w := NewFileWrite("TestBatch")
+	_, err := w.Init(false, prefix, "log", "log", "log",
+		true, false, false, false, 5, 0, false, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, _, err = w.WriteString("single\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//整批超出最大行数, 写入前切换文件
+	batch := [][]byte{[]byte("r1\n"), []byte("r2\n"), []byte("r3\n"), []byte("r4\n")}
+	fileName, firstNo, lastNo, err := w.WriteBatch(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if firstNo != 1 || lastNo != 4 {
+		t.Fatalf("batch lines [%d,%d], want [1,4]", firstNo, lastNo)
+	}
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "r1\nr2\nr3\nr4\n" {
+		t.Fatalf("batch file content %q", data)
+	}
+
+	//超过单次writev最大向量数的批量合并后一次写入, 不修改调用者数据
+	fd, err := os.Create(prefix + ".iov")
+	if err != nil {
+		t.Fatal(err)
+	}
+	big := make([][]byte, 2048)
+	for i := range big {
+		big[i] = []byte(fmt.Sprintf("v%d\n", i))
+	}
+	n, err := writev(fd, big)
+	fd.Close()
+	data, _ = os.ReadFile(prefix + ".iov")
+	if err != nil || n != len(data) || !bytes.Equal(data, bytes.Join(big, nil)) ||
+		string(big[0]) != "v0\n" {
+		t.Fatalf("writev %d bytes, err %v", n, err)
+	}
+
+	//并发单条与批量写入, 返回的行号与文件内容一致
+	w2 := NewFileWrite("TestBatch2")
+	if _, err = w2.Init(false, prefix+"2", "log", "log", "log",
+		true, false, false, false, 1000, 0, false, 3); err != nil {
+		t.Fatal(err)
+	}
+	defer w2.Close()
+	var mu sync.Mutex
+	want := make(map[int64]string)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				rec := fmt.Sprintf("g%d-%d", g, i)
+				if g%2 == 0 {
+					_, lineNo, _ := w2.WriteString(rec + "\n")
+					mu.Lock()
+					want[lineNo] = rec
+					mu.Unlock()
+					continue
+				}
+				_, firstNo, _, _ := w2.WriteBatch([][]byte{[]byte(rec + "a\n"), []byte(rec + "b\n")})
+				mu.Lock()
+				want[firstNo], want[firstNo+1] = rec+"a", rec+"b"
+				mu.Unlock()
+			}
+		}(g)
+	}
+	wg.Wait()
+	w2.Flush()
+	data, _ = os.ReadFile(w2.cfg.FileName)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	for i, line := range lines {
+		if want[int64(i+1)] != line {
+			t.Fatalf("line %d %q, want %q", i+1, line, want[int64(i+1)])
+		}
+	}
+}
+
+func TestWriteState(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "TestState")
+	newWrite := func() *FileWrite {
+		w := NewFileWrite("TestState")
+		w.SetStateFile(true)
+		_, err := w.Init(false, prefix, "log", "log", "log",
+			true, false, false, false, 100, 0, false, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	w := newWrite()
+	defer w.Close()
+	for i := 0; i < 3; i++ {
+		w.WriteString("state\n")
+	}
+	w.Flush()
+	fileName := w.cfg.FileName
+
+	//状态文件一致, 直接采用状态文件行数
+	st, err := loadFileState(fileName)
+	if err != nil || st.Lines != 3 || st.Offset != 12 {
+		t.Fatalf("state %+v, err %v", st, err)
+	}
+	st.Lines = 42
+	saveFileState(fileName, st)
+	w2 := newWrite()
+	defer w2.Close()
+	if w2.cfg.CurLines != 42 {
+		t.Fatalf("trusted state lines %d, want 42", w2.cfg.CurLines)
+	}
+
+	//尾部不一致, 重新扫描文件
+	st.Tail++
+	saveFileState(fileName, st)
+	w3 := newWrite()
+	defer w3.Close()
+	if w3.cfg.CurLines != 3 {
+		t.Fatalf("rescan lines %d, want 3", w3.cfg.CurLines)
+	}
+}
+
+func TestWriteHeader(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "TestHeader")
+	w := NewFileWrite("TestHeader")
+	w.SetFileHeader([]byte("id,name\n"), nil, false)
+	_, err := w.Init(false, prefix, "log", "log", "log",
+		true, false, false, false, 2, 0, false, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	var files []string
+	for i := 1; i <= 4; i++ {
+		fileName, lineNo, err := w.WriteString(fmt.Sprintf("%d,n%d\n", i, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := int64((i-1)%2 + 1); lineNo != want {
+			t.Fatalf("%d: lineNo %d, want %d", i, lineNo, want)
+		}
+		if lineNo == 1 {
+			files = append(files, fileName)
+		}
+	}
+	rotated, err := filepath.Glob(prefix + ".log.*.log")
+	if err != nil || len(rotated) != 1 {
+		t.Fatalf("rotated files %v, err %v", rotated, err)
+	}
+	for _, fileName := range []string{rotated[0], files[1]} {
+		data, _ := os.ReadFile(fileName)
+		if !bytes.HasPrefix(data, []byte("id,name\n")) || bytes.Count(data, lineSep) != 3 {
+			t.Fatalf("%s content %q", fileName, data)
+		}
+	}
+
+	//生成的文件头在无状态文件时重新打开, 按记录的文件头行数计算行数
+	prefix2 := prefix + "Func"
+	newWrite := func() *FileWrite {
+		w := NewFileWrite("TestHeaderFunc")
+		w.SetFileHeader(nil, func(info *HeaderInfo) []byte {
+			return []byte("# " + info.OpenTime.String() + "\n# id,name\n")
+		}, false)
+		_, err := w.Init(false, prefix2, "log", "log", "log",
+			true, false, false, false, 3, 0, false, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+	w2 := newWrite()
+	defer w2.Close()
+	w2.WriteString("1,n1\n")
+	w2.Flush()
+	w3 := newWrite()
+	defer w3.Close()
+	if w3.cfg.CurLines != 1 || w3.cfg.HeadLines != 2 {
+		t.Fatalf("reopen lines %d, head %d", w3.cfg.CurLines, w3.cfg.HeadLines)
+	}
+	if _, lineNo, _ := w3.WriteString("2,n2\n"); lineNo != 2 {
+		t.Fatalf("reopen lineNo %d, want 2", lineNo)
+	}
+}
+
+func TestWriteTrailer(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "TestTrailer")
+	w := NewFileWrite("TestTrailer")
+	var nextNames []string
+	w.SetFileTrailer(nil, func(st *FileStats) []byte {
+		nextNames = append(nextNames, st.NextName)
+		return []byte(fmt.Sprintf("#END records=%d bytes=%d sha256=%x\n",
+			st.Records, st.Bytes, st.Sha256))
+	})
+	_, err := w.Init(false, prefix, "log", "log", "log",
+		true, false, false, false, 2, 0, false, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for i := 0; i < 3; i++ {
+		w.WriteString("trailer\n")
+	}
+	rotated, _ := filepath.Glob(prefix + ".log.*.log")
+	if len(rotated) != 1 {
+		t.Fatalf("rotated files %v", rotated)
+	}
+	data, _ := os.ReadFile(rotated[0])
+	content := []byte("trailer\ntrailer\n")
+	want := fmt.Sprintf("%s#END records=2 bytes=%d sha256=%x\n",
+		content, len(content), sha256.Sum256(content))
+	if string(data) != want {
+		t.Fatalf("sealed content %q, want %q", data, want)
+	}
+
+	//旋转时后续文件为活动文件, 关闭时为空
+	w.Close()
+	if len(nextNames) != 2 || nextNames[0] != prefix+".log" || nextNames[1] != "" {
+		t.Fatalf("next names %q", nextNames)
+	}
+}
+
+func TestWriteRepair(t *testing.T) {
+	dir := t.TempDir()
+	for _, c := range []struct {
+		mode  int
+		ended bool
+		want  string
+		lines int64
+	}{
+		{RepairTruncate, true, "a\nb\nc\n", 3},
+		{RepairSeal, true, "a\nb\npart\n#TORN\nc\n", 5},
+		//记录不保证以换行结尾时, 最后一行是完整记录, 不修复
+		{RepairTruncate, false, "a\nb\npartc\n", 3},
+	} {
+		prefix := filepath.Join(dir, fmt.Sprintf("TestRepair%d%v", c.mode, c.ended))
+		os.WriteFile(prefix+".log", []byte("a\nb\npart"), 0660)
+
+		w := NewFileWrite("TestRepair")
+		w.SetTailRepair(c.mode, nil)
+		if c.ended {
+			w.SetLineMode(true, true, NewlineKeep)
+		}
+		_, err := w.Init(false, prefix, "log", "log", "log",
+			true, false, false, false, 100, 0, false, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report := w.LastRepair()
+		if c.ended && (report == nil || report.Offset != 4 || report.Torn != 4) ||
+			!c.ended && report != nil {
+			t.Fatalf("mode %d repair report %+v", c.mode, report)
+		}
+		if _, lineNo, _ := w.WriteString("c\n"); lineNo != c.lines {
+			t.Fatalf("mode %d lineNo %d, want %d", c.mode, lineNo, c.lines)
+		}
+		if data, _ := os.ReadFile(prefix + ".log"); string(data) != c.want {
+			t.Fatalf("mode %d content %q, want %q", c.mode, data, c.want)
+		}
+		w.muwt.file.Close()
+	}
+
+	//帧模式只修复文件结尾的不完整帧, 中间损坏帧不修改数据
+	a, b := appendFrame(nil, []byte("a")), appendFrame(nil, []byte("b"))
+	bad := append([]byte(nil), b...)
+	bad[len(bad)-1] = 'x'
+	for i, c := range []struct {
+		data   []byte
+		action string
+		size   int64
+	}{
+		{append(append([]byte(nil), a...), b[:5]...), "truncate", int64(len(a))},
+		{append(append(append([]byte(nil), a...), bad...), b...), "none", int64(len(a) + 2*len(b))},
+	} {
+		prefix := filepath.Join(dir, fmt.Sprintf("TestRepairFrame%d", i))
+		os.WriteFile(prefix+".log", c.data, 0660)
+
+		w := NewFileWrite("TestRepair")
+		w.SetFrameMode(true)
+		w.SetTailRepair(RepairTruncate, nil)
+		_, err := w.Init(false, prefix, "log", "log", "log",
+			true, false, false, false, 100, 0, false, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report := w.LastRepair()
+		if report == nil || report.Action != c.action || report.Offset != int64(len(a)) {
+			t.Fatalf("frame %d repair report %+v", i, report)
+		}
+		if c.action == "none" && !errors.Is(report.Err, ErrFrameCorrupt) {
+			t.Fatalf("frame %d repair err %v", i, report.Err)
+		}
+		if stat, _ := os.Stat(prefix + ".log"); stat.Size() != c.size {
+			t.Fatalf("frame %d size %d, want %d", i, stat.Size(), c.size)
+		}
+		w.muwt.file.Close()
+	}
+
+	//帧模式封存多次, 不完整数据逐帧追加到.torn文件, 随文件重命名
+	prefix := filepath.Join(dir, "TestRepairTorn")
+	newWrite := func(torn []byte) *FileWrite {
+		fd, _ := os.OpenFile(prefix+".log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0660)
+		fd.Write(torn)
+		fd.Close()
+		w := NewFileWrite("TestRepair")
+		w.SetFrameMode(true)
+		w.SetTailRepair(RepairSeal, nil)
+		_, err := w.Init(false, prefix, "log", "log", "log",
+			true, false, false, false, 100, 0, false, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+	os.WriteFile(prefix+".log", a, 0660)
+	newWrite(b[:5]).muwt.file.Close()
+	w := newWrite(b[:3])
+	var torn [][]byte
+	data, _ := os.ReadFile(prefix + ".log" + TornSuffix)
+	br := bufio.NewReader(bytes.NewReader(data))
+	for {
+		var partial []byte
+		frame, _, err := readFrame(br, &partial)
+		if err != nil {
+			break
+		}
+		torn = append(torn, append([]byte(nil), frame...))
+	}
+	if len(torn) != 2 || !bytes.Equal(torn[0], b[:5]) || !bytes.Equal(torn[1], b[:3]) {
+		t.Fatalf("torn frames %q", torn)
+	}
+	w.Rotate()
+	w.Close()
+	if sealed, _ := filepath.Glob(prefix + ".log.*.log" + TornSuffix); len(sealed) != 1 ||
+		FileExist(prefix+".log"+TornSuffix) {
+		t.Fatalf("torn file not moved: %v", sealed)
+	}
+}
+
+func TestWriteManifest(t *testing.T) {
+	dir := t.TempDir()
+	prefix := filepath.Join(dir, "TestManifest")
+	w := NewFileWrite("TestManifest")
+	w.SetManifest(true)
+	_, err := w.Init(false, prefix, "log", "log", "log",
+		true, false, false, false, 2, 0, true, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for i := 1; i <= 9; i++ {
+		w.WriteString(fmt.Sprintf("record-%d\n", i))
+	}
+
+	entries, err := LoadManifest(dir)
+	if err != nil || len(entries) != 4 {
+		t.Fatalf("manifest entries %d, err %v", len(entries), err)
+	}
+	for _, entry := range entries {
+		if entry.Lines != 2 || entry.Size != 18 || entry.FirstTime.IsZero() {
+			t.Fatalf("manifest entry %+v", entry)
+		}
+		if err = entry.Check(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//压缩文件替换原记录
+	first := filepath.Join(dir, entries[0].Name)
+	zipReport(w.cfg, w._Name_, first)
+	if st := w.Errors(); st.Compress != 0 {
+		t.Fatalf("zip errors %+v", st)
+	}
+	entries, _ = LoadManifest(dir)
+	last := entries[len(entries)-1]
+	if len(entries) != 4 || last.Source != filepath.Base(first) || last.Lines != 2 {
+		t.Fatalf("zip manifest entry %+v", last)
+	}
+	if err = last.Check(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	//篡改后校验失败
+	second := filepath.Join(dir, entries[0].Name)
+	os.WriteFile(second, []byte("record-X\nrecord-4\n"), 0660)
+	if err = entries[0].Check(dir); !errors.Is(err, ErrManifestMismatch) {
+		t.Fatalf("check tampered err %v", err)
+	}
+
+	//清理文件时删除记录
+	for i, name := range []string{second, first + zipFileSuffix,
+		filepath.Join(dir, entries[1].Name), filepath.Join(dir, entries[2].Name)} {
+		old := time.Now().AddDate(0, 0, -10+i)
+		os.Chtimes(name, old, old)
+	}
+	if err, cleaned := w.FileClean(); err != nil || len(cleaned) != 1 {
+		t.Fatalf("clean %v, err %v", cleaned, err)
+	}
+	if entries, _ = LoadManifest(dir); len(entries) != 3 {
+		t.Fatalf("manifest entries %d after clean", len(entries))
+	}
+}
+
+func TestWriteManifestStats(t *testing.T) {
+	keys := &StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}}
+	for i, c := range []struct {
+		frame bool
+		keys  KeyProvider
+	}{{false, nil}, {true, nil}, {false, keys}, {true, keys}} {
+		dir := t.TempDir()
+		prefix := filepath.Join(dir, "TestManifestStats")
+		open := func() *FileWrite {
+			w := NewFileWrite("TestManifestStats")
+			w.SetManifest(true)
+			w.SetFrameMode(c.frame)
+			w.SetKeyProvider(c.keys)
+			w.SetChain(2, nil)
+			w.SetFileHeader([]byte("#head\n"), nil, false)
+			_, err := w.Init(false, prefix, "log", "log", "log",
+				true, false, false, false, 3, 0, false, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return w
+		}
+
+		//重新打开后续写, 校验清单仍与文件内容一致
+		w := open()
+		w.Write([]byte("record-1\n"))
+		w.WriteString("record-2\n")
+		w.muwt.file.Close()
+		w = open()
+		w.WriteBatch([][]byte{[]byte("record-3\n"), []byte("record-4\n")})
+		for j := 5; j <= 9; j++ {
+			w.WriteString(fmt.Sprintf("record-%d\n", j))
+		}
+		w.Close()
+
+		entries, err := LoadManifest(dir)
+		if err != nil || len(entries) < 2 {
+			t.Fatalf("case %d manifest entries %d, err %v", i, len(entries), err)
+		}
+		for _, entry := range entries {
+			want, err := newManifestEntry(filepath.Join(dir, entry.Name), c.frame, c.keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.Size != want.Size || entry.Sha256 != want.Sha256 || entry.Lines != want.Lines {
+				t.Fatalf("case %d manifest entry %+v, want %+v", i, entry, want)
+			}
+		}
+	}
+}
+
+func TestWriteCryptPlain(t *testing.T) {
+	dir := t.TempDir()
+	prefix := filepath.Join(dir, "TestCryptPlain")
+	os.WriteFile(prefix+".log", []byte("plain-1\n"), 0660)
+
+	//不重命名时已有明文文件无法续写, 返回错误而不是反复打开
+	w := NewFileWrite("TestCryptPlain")
+	w.cfg.RotateRenameSuffix = true
+	w.SetKeyProvider(&StaticKeys{Current: "k1",
+		Keys: map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}})
+	done := make(chan error, 1)
+	go func() {
+		_, err := w.Init(false, prefix, "log", "log", "log",
+			true, false, false, false, 3, 0, false, 3)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		var renameErr *RenameError
+		if !errors.As(err, &renameErr) || !errors.Is(err, errCryptAppend) {
+			t.Fatalf("init err %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("init not return")
+	}
+	if data, _ := os.ReadFile(prefix + ".log"); string(data) != "plain-1\n" {
+		t.Fatalf("plain file %q", data)
+	}
+}
+
+//共享模式每次写入加锁、同步并保存锁文件状态
+func BenchmarkWriteShared(b *testing.B) {
+	prefix := filepath.Join(b.TempDir(), "BenchShared")
+	w := NewFileWrite("BenchShared")
+	w.SetSharedMode(true)
+	_, err := w.Init(false, prefix, "log", "log", "log",
+		true, false, false, false, 1<<30, 0, false, 3)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer w.Close()
+
+	data := []byte("benchmark shared record\n")
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.Write(data)
+	}
+}
+
+func TestWriteShared(t *testing.T) {
+	dir := t.TempDir()
+	prefix := filepath.Join(dir, "TestShared")
+	newShared := func(name string) *FileWrite {
+		w := NewFileWrite(name)
+		w.SetSharedMode(true)
+		_, err := w.Init(false, prefix, "log", "log", "log",
+			true, false, false, false, 5, 0, false, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+	//两个写入器模拟两个进程
+	a, b := newShared("TestSharedA"), newShared("TestSharedB")
+	for i := 1; i <= 12; i++ {
+		w := a
+		if i%2 == 0 {
+			w = b
+		}
+		fileName, lineNo, err := w.Write([]byte(fmt.Sprintf("record-%02d\n", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := int64((i-1)%5 + 1); lineNo != want || fileName != prefix+".log" {
+			t.Fatalf("record %d at %s:%d, want line %d", i, fileName, lineNo, want)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, w := range []*FileWrite{a, b} {
+		wg.Add(1)
+		go func(w *FileWrite) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				w.WriteString("concurrent\n")
+			}
+		}(w)
+	}
+	wg.Wait()
+	a.Close()
+	b.Close()
+
+	names, _ := filepath.Glob(prefix + ".log*.log")
+	total := 0
+	for _, name := range append(names, prefix+".log") {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := bytes.Count(data, []byte("\n")); n > 5 || (name != prefix+".log" && n != 5) {
+			t.Fatalf("%s has %d lines", name, n)
+		} else {
+			total += n
+		}
+	}
+	if total != 112 || len(names) != 22 {
+		t.Fatalf("total %d lines in %d sealed files", total, len(names))
+	}
+
+	//锁文件状态以换行结束, 较短的新状态覆盖写入后忽略之后的残留
+	fd, err := os.OpenFile(prefix+".log"+LockSuffix, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(prefix + ".log")
+	st := loadShared(fd, make([]byte, sharedStateSize))
+	if st.File != prefix+".log" || st.Size != int64(len(data)) || st.Lines != int64(bytes.Count(data, []byte("\n"))) {
+		t.Fatalf("shared state %+v", st)
+	}
+	fd.WriteAt([]byte(`{"file":"x"}`+"\n"), 0)
+	if st = loadShared(fd, make([]byte, sharedStateSize)); st.File != "x" || st.Lines != 0 {
+		t.Fatalf("shared state %+v after overwrite", st)
+	}
+	fd.Close()
+
+	//依赖进程内状态的功能不能与共享模式同时使用
+	for name, set := range map[string]func(w *FileWrite){
+		"state file": func(w *FileWrite) { w.SetStateFile(true) },
+		"manifest":   func(w *FileWrite) { w.SetManifest(true) },
+		"trailer": func(w *FileWrite) {
+			w.SetFileTrailer(nil, func(st *FileStats) []byte { return nil })
+		},
+	} {
+		c := NewFileWrite("TestSharedC")
+		c.SetSharedMode(true)
+		set(c)
+		_, err := c.Init(false, prefix, "log", "log", "log",
+			true, false, false, false, 5, 0, false, 3)
+		if !errors.Is(err, ErrSharedConfig) {
+			t.Fatalf("shared with %s err %v", name, err)
+		}
+	}
+}
+
+func TestWriteExclusive(t *testing.T) {
+	dir := t.TempDir()
+	prefix := filepath.Join(dir, "TestExclusive")
+	newOwner := func(name string, wait time.Duration) (*FileWrite, error) {
+		w := NewFileWrite(name)
+		w.SetLockMode(true, wait)
+		_, err := w.Init(false, prefix, "log", "log", "log",
+			true, false, false, false, 5, 0, false, 3)
+		return w, err
+	}
+	a, err := newOwner("TestExclusiveA", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.WriteString("owner-a\n")
+
+	//不等待时立即失败, 错误中包含持有者
+	_, err = newOwner("TestExclusiveB", 0)
+	var lockErr *LockError
+	if !errors.As(err, &lockErr) || !errors.Is(err, ErrLockHeld) ||
+		lockErr.Pid != os.Getpid() {
+		t.Fatalf("exclusive init err %v", err)
+	}
+	if host, _ := os.Hostname(); lockErr.Host != host {
+		t.Fatalf("lock holder host %s, want %s", lockErr.Host, host)
+	}
+	if _, err = newOwner("TestExclusiveC", 50*time.Millisecond); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("exclusive wait err %v", err)
+	}
+
+	//持有者关闭后等待者接管
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		a.Close()
+	}()
+	d, err := newOwner("TestExclusiveD", 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if _, lineNo, _ := d.WriteString("owner-d\n"); lineNo != 1 {
+		t.Fatalf("takeover line %d", lineNo)
+	}
+}
+
+func TestWriteLockOwner(t *testing.T) {
+	dir := t.TempDir()
+	prefix := filepath.Join(dir, "TestOwner")
+	w := NewFileWrite("TestOwner")
+	w.SetLockMode(true, 0)
+	w.SetLockRefresh(20 * time.Millisecond)
+	_, err := w.Init(false, prefix, "log", "log", "log",
+		true, false, false, false, 5, 0, false, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	owner := readLockOwner(prefix + ".log" + LockSuffix)
+	if owner.Pid != os.Getpid() || owner.Name != "TestOwner" || owner.Start.IsZero() ||
+		pidReused(owner.Pid, owner.Boot) {
+		t.Fatalf("lock owner %+v", owner)
+	}
+	//刷新时锁文件会短暂为空
+	refreshed := owner
+	for i := 0; i < 50 && !refreshed.Refresh.After(owner.Refresh); i++ {
+		time.Sleep(10 * time.Millisecond)
+		refreshed = readLockOwner(prefix + ".log" + LockSuffix)
+	}
+	if !refreshed.Refresh.After(owner.Refresh) || refreshed.Start != owner.Start {
+		t.Fatalf("lock owner not refreshed %+v", refreshed)
+	}
+
+	//同一主机按进程存活及启动时间判断, 无持有者信息按修改时间判断
+	host, _ := os.Hostname()
+	writeOwner := func(name string, pid int, boot time.Time, days int) string {
+		name = prefix + name + ".log" + LockSuffix
+		if pid > 0 {
+			data, _ := json.Marshal(&lockOwner{Pid: pid, Host: host, Boot: boot})
+			os.WriteFile(name, data, 0660)
+		} else {
+			os.WriteFile(name, nil, 0660)
+		}
+		old := time.Now().AddDate(0, 0, -days)
+		os.Chtimes(name, old, old)
+		return name
+	}
+	dead := writeOwner(".dead", 1<<22+1, time.Time{}, 0)
+	alive := writeOwner(".alive", os.Getpid(), owner.Boot, 3)
+	empty := writeOwner(".empty", 0, time.Time{}, 3)
+	reused := ""
+	if !owner.Boot.IsZero() {
+		reused = writeOwner(".reused", os.Getpid(), owner.Boot.Add(-time.Hour), 0)
+	}
+	for w.lockClean(w.cfg.FileName) != nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if FileExist(dead) || !FileExist(alive) || FileExist(empty) ||
+		!FileExist(prefix+".log"+LockSuffix) || (reused != "" && FileExist(reused)) {
+		t.Fatalf("lock clean dead %v, alive %v, empty %v, reused %v",
+			FileExist(dead), FileExist(alive), FileExist(empty), reused)
+	}
+}
+
+func TestFileRegistry(t *testing.T) {
+	dir := t.TempDir()
+	r1, r2 := NewFileRegistry(), NewFileRegistry()
+	newWriter := func(name string, registry *FileRegistry) *FileWrite {
+		w := NewFileWrite(name)
+		w.SetRegistry(registry)
+		_, err := w.Init(false, filepath.Join(dir, name), "log", "log", "log",
+			true, false, false, false, 2, 0, false, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+	w1, w2 := newWriter("TestRegistry1", r1), newWriter("TestRegistry2", r2)
+	name := w1.cfg.FileName
+	if len(r1.Holders(name)) != 1 || r2.Exists(name) || FileLocked(name) {
+		t.Fatalf("holders %v, other %v, default %v",
+			r1.Holders(name), r2.Exists(name), FileLocked(name))
+	}
+
+	//切换后新文件替换旧登记
+	for i := 0; i < 3; i++ {
+		w1.WriteString("record\n")
+	}
+	if holders := r1.Holders(name); len(holders) != 1 || holders[0] != w1.muwt.file {
+		t.Fatalf("holders after rotate %v", holders)
+	}
+	w1.Close()
+	w2.Close()
+	if r1.Exists(name) || r2.Exists(w2.cfg.FileName) {
+		t.Fatal("registry not released on close")
+	}
+
+	fd, err := os.Create(filepath.Join(dir, "TestRegistry.tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	if !r1.Acquire(fd) || r1.Acquire(fd) || !r1.Release(fd) || r1.Release(fd) {
+		t.Fatal("acquire/release result")
+	}
+
+	//跟踪信息运行时切换
+	var buf bytes.Buffer
+	SetDefaultLogger(NewTextLogger(&buf, LevelTrace))
+	defer SetDefaultLogger(nil)
+	r1.Exists(fd.Name())
+	if buf.Len() != 0 {
+		t.Fatalf("trace off output %q", buf.String())
+	}
+	r1.SetTrace(true)
+	if r1.Exists(fd.Name()); !bytes.Contains(buf.Bytes(), []byte(`writer="$FileRegistry" file="`+fd.Name()+`" op="exists"`)) {
+		t.Fatalf("trace on output %q", buf.String())
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	os.Mkdir(dir, 0770)
+	var mu sync.Mutex
+	var errs []error
+	w := NewFileWrite("TestErrors")
+	w.SetLogger(NewTextLogger(io.Discard, LevelInfo))
+	w.SetOnError(func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	})
+	_, err := w.Init(false, filepath.Join(dir, "TestErrors"), "log", "log", "log",
+		true, false, false, false, 1, 0, false, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	//目录被删除后旋转失败, 重命名和旋转错误均被报告
+	w.WriteString("record-1\n")
+	os.RemoveAll(dir)
+	fileName, lineNo, err := w.WriteString("record-2\n")
+	if !errors.As(err, new(*RotateError)) || fileName != w.cfg.FileName ||
+		lineNo != w.cfg.CurLines || w.cfg.CurSize != int64(len("record-1\nrecord-2\n")) {
+		t.Fatalf("write after rotate failure %s:%d, err %v", fileName, lineNo, err)
+	}
+	//每种写入方式都返回旋转错误
+	if _, _, err = w.Write([]byte("record-3\n")); !errors.As(err, new(*RotateError)) {
+		t.Fatalf("write err %v", err)
+	}
+	if _, err = w.WritePos([]byte("record-4\n")); !errors.As(err, new(*RotateError)) {
+		t.Fatalf("write pos err %v", err)
+	}
+	if _, _, _, err = w.WriteBatch([][]byte{[]byte("record-5\n")}); !errors.As(err, new(*RotateError)) {
+		t.Fatalf("write batch err %v", err)
+	}
+	if w.cfg.CurLines != 5 {
+		t.Fatalf("rotate failure lines %d", w.cfg.CurLines)
+	}
+	var rotateErr *RotateError
+	var renameErr *RenameError
+	mu.Lock()
+	for _, e := range errs {
+		errors.As(e, &rotateErr)
+		errors.As(e, &renameErr)
+	}
+	mu.Unlock()
+	if rotateErr == nil || renameErr == nil || !errors.Is(rotateErr, os.ErrNotExist) {
+		t.Fatalf("rotate %v, rename %v", rotateErr, renameErr)
+	}
+
+	zipReport(w.cfg, w._Name_, filepath.Join(dir, "missing.log"))
+	var compressErr *CompressError
+	if mu.Lock(); !errors.As(errs[len(errs)-1], &compressErr) {
+		t.Fatalf("compress err %v", errs[len(errs)-1])
+	}
+	mu.Unlock()
+	if st := w.Errors(); st.Rotate != 4 || st.Rename != 4 || st.Compress != 1 {
+		t.Fatalf("error stats %+v", st)
+	}
+}
+
+func TestWriteRenameError(t *testing.T) {
+	//重命名后的文件名超过长度限制, 旧文件重命名失败, 活动文件仍可打开
+	prefix := filepath.Join(t.TempDir(), strings.Repeat("r", 240))
+	w := NewFileWrite("TestRenameError")
+	w.SetLogger(NewTextLogger(io.Discard, LevelInfo))
+	_, err := w.Init(false, prefix, "log", "log", "log",
+		true, false, false, false, 100, 0, false, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	w.WriteString("record-1\n")
+	var renameErr *RenameError
+	if err = w.Rotate(); !errors.As(err, &renameErr) || renameErr.From != prefix+".log" {
+		t.Fatalf("rotate err %v", err)
+	}
+	if fileName, _, err := w.WriteString("record-2\n"); err != nil || fileName != prefix+".log" {
+		t.Fatalf("write after rename failure %s, err %v", fileName, err)
+	}
+	w.Flush()
+	if data, _ := os.ReadFile(prefix + ".log"); string(data) != "record-1\nrecord-2\n" {
+		t.Fatalf("active file %q", data)
+	}
+	if st := w.Errors(); st.Rename != 1 {
+		t.Fatalf("error stats %+v", st)
+	}
+}
+
+func TestWriteEvents(t *testing.T) {
+	dir := t.TempDir()
+	var mu sync.Mutex
+	var evs []Event
+	hook := func(ev Event) {
+		mu.Lock()
+		evs = append(evs, ev)
+		mu.Unlock()
+	}
+	events := make(chan Event, 16)
+	w := NewFileWrite("TestEvents")
+	w.SetHooks(Hooks{OnOpen: hook, OnRotate: hook, OnSeal: hook})
+	w.SetEvents(events)
+	w.cfg.SetTrailerFunc(func(stats *FileStats) []byte { return nil })
+	_, err := w.Init(false, filepath.Join(dir, "TestEvents"), "log", "log", "log",
+		true, false, true, false, 1, 0, false, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	first := w.cfg.FileName
+	w.WriteString("record-1\n")
+	w.WriteString("record-2\n")
+
+	mu.Lock()
+	var types []string
+	for _, ev := range evs {
+		types = append(types, ev.Type.String())
+	}
+	mu.Unlock()
+	if got := strings.Join(types, ","); got != "open,rotate-start,seal,open,rotate" {
+		t.Fatalf("events %s", got)
+	}
+	start, seal, done := evs[1], evs[2], evs[4]
+	if start.Reason != RotateLines || start.FileName != first || start.Lines != 1 {
+		t.Fatalf("rotate start %+v", start)
+	}
+	if seal.FileName != first || seal.NewName == "" || seal.Stats == nil ||
+		seal.Stats.Records != 1 || seal.Name != "TestEvents" {
+		t.Fatalf("seal %+v", seal)
+	}
+	if done.Sealed != seal.NewName || done.NewName != w.cfg.FileName || done.Stats == nil {
+		t.Fatalf("rotate %+v", done)
+	}
+
+	//通道收到全部事件, 压缩在后台完成
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Type != EventCompress {
+				continue
+			}
+			if ev.FileName != seal.NewName || !FileExist(ev.NewName) {
+				t.Fatalf("compress %+v", ev)
+			}
+			return
+		case <-timeout:
+			t.Fatal("compress event timeout")
+		}
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	dir := t.TempDir()
+	w := NewFileWrite("TestMetrics")
+	_, err := w.Init(false, filepath.Join(dir, "TestMetrics"), "log", "log", "log",
+		true, false, false, false, 2, 0, false, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for i := 0; i < 3; i++ {
+		w.WriteString("record\n")
+	}
+	w.WriteBatch([][]byte{[]byte("batch-1\n"), []byte("batch-2\n")})
+	w.Flush()
+	w.Rotate()
+
+	st := w.Stats()
+	if st.Writes != 4 || st.Records != 5 || st.Bytes != 3*7+2*8 || st.InFlight != 0 {
+		t.Fatalf("stats %+v", st)
+	}
+	if st.Rotations[RotateLines] != 1 || st.Rotations[RotateBatch] != 1 ||
+		st.Rotations[RotateManual] != 1 {
+		t.Fatalf("rotations %v", st.Rotations)
+	}
+	if st.WriteLatency.Count != 4 || st.WriteLatency.Counts[len(st.WriteLatency.Counts)-1] > 4 ||
+		st.SyncLatency.Count != 1 || st.FileName != w.cfg.FileName || st.CurLines != 0 {
+		t.Fatalf("stats %+v", st)
+	}
+
+	rec := httptest.NewRecorder()
+	MetricsHandler(w).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE fwrite_write_duration_seconds histogram\n",
+		`fwrite_write_duration_seconds_bucket{writer="TestMetrics",le="+Inf"} 4`,
+		`fwrite_rotations_total{writer="TestMetrics",reason="lines"} 1`,
+		`fwrite_bytes_written_total{writer="TestMetrics"} 37`,
+		`fwrite_errors_total{writer="TestMetrics",type="rename"} 0`,
+		`fwrite_writes_in_flight{writer="TestMetrics"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics missing %q:\n%s", want, body)
+		}
+	}
+
+	PublishExpvar("fwrite.TestMetrics", w)
+	var vars map[string]WriterStats
+	if err = json.Unmarshal([]byte(expvar.Get("fwrite.TestMetrics").String()), &vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars["TestMetrics"].Records != 5 {
+		t.Fatalf("expvar %+v", vars)
+	}
+	//同名重复发布改为输出新的写入器
+	PublishExpvar("fwrite.TestMetrics")
+	if s := expvar.Get("fwrite.TestMetrics").String(); s != "{}" {
+		t.Fatalf("expvar republished %s", s)
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	dir := t.TempDir()
+	w := NewFileWrite("TestAdmin")
+	_, err := w.Init(false, filepath.Join(dir, "TestAdmin"), "log", "log", "log",
+		true, false, false, false, 10, 0, false, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.WriteString("record-1\n")
+	w.WriteString("record-2\n")
+
+	admin := NewAdmin(w)
+	serve := func(method, target string, status int, v interface{}) string {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set(AdminHeader, "1")
+		admin.ServeHTTP(rec, req)
+		if rec.Code != status {
+			t.Fatalf("%s %s status %d: %s", method, target, rec.Code, rec.Body.String())
+		}
+		if v != nil {
+			if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Body.String()
+	}
+
+	var infos []WriterInfo
+	serve("GET", "/writers", 200, &infos)
+	if len(infos) != 1 || infos[0].Name != "TestAdmin" || infos[0].Lines != 2 ||
+		infos[0].FileName != w.cfg.FileName || infos[0].Config.MaxLines != 10 {
+		t.Fatalf("writers %+v", infos)
+	}
+	serve("GET", "/writers/TestAdmin/rotate", 405, nil)
+	serve("GET", "/writers/Missing", 404, nil)
+
+	//没有管理请求头的表单提交被拒绝
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/writers/TestAdmin/rotate", strings.NewReader("dryRun=false"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if admin.ServeHTTP(rec, req); rec.Code != 403 || w.Info().Lines != 2 {
+		t.Fatalf("form post status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var info WriterInfo
+	serve("POST", "/writers/TestAdmin/rotate", 200, &info)
+	serve("POST", "/writers/TestAdmin/flush", 200, nil)
+	var files []SealedFile
+	serve("GET", "/writers/TestAdmin/files", 200, &files)
+	if info.Lines != 0 || len(files) != 1 || files[0].Size != 18 {
+		t.Fatalf("rotate %+v, files %+v", info, files)
+	}
+
+	serve("POST", "/writers/TestAdmin/retention?cleaning=true&maxDays=1", 400, nil)
+	serve("POST", "/writers/TestAdmin/retention?cleaning=true&maxDays=5&maxAgeDays=30", 200, &info)
+	if info.Config.MaxDays != 5 || info.Config.MaxAgeDays != 30 || !info.Config.Cleaning {
+		t.Fatalf("retention %+v", info.Config)
+	}
+	//并发修改不同项不会互相覆盖
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		target := "/writers/TestAdmin/retention?maxDays=7"
+		if i%2 == 1 {
+			target = "/writers/TestAdmin/retention?maxAgeDays=40"
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("POST", target, nil)
+			req.Header.Set(AdminHeader, "1")
+			admin.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+	}
+	wg.Wait()
+	if cfg := w.Info().Config; cfg.MaxDays != 7 || cfg.MaxAgeDays != 40 || !cfg.Cleaning {
+		t.Fatalf("concurrent retention %+v", cfg)
+	}
+	serve("POST", "/writers/TestAdmin/retention?maxDays=3&maxAgeDays=30", 200, nil)
+
+	//超过保留天数的最早两个文件被清理
+	for i := 10; i < 15; i++ {
+		old := time.Now().AddDate(0, 0, -i)
+		name := filepath.Join(dir, "TestAdmin.log."+old.Format("2006-01-02")+".001.log")
+		os.WriteFile(name, []byte("old\n"), 0660)
+		os.Chtimes(name, old, old)
+	}
+	var clean struct {
+		DryRun bool
+		Files  []string
+	}
+	serve("POST", "/writers/TestAdmin/clean?dryRun=true", 200, &clean)
+	if !clean.DryRun || len(clean.Files) != 2 || !FileExist(clean.Files[0]) {
+		t.Fatalf("dry run %+v", clean)
+	}
+	serve("POST", "/writers/TestAdmin/clean", 200, &clean)
+	if clean.DryRun || len(clean.Files) != 2 || FileExist(clean.Files[0]) {
+		t.Fatalf("clean %+v", clean)
+	}
+
+	if body := serve("GET", "/metrics", 200, nil); !strings.Contains(body,
+		`fwrite_files_deleted_total{writer="TestAdmin"} 2`) {
+		t.Fatalf("metrics %s", body)
+	}
+}
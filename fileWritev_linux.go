@@ -0,0 +1,75 @@
+// fileWritev
+package fwrite
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	maxIovecs = 1024 //单次writev最大向量数
+)
+
+//向量写文件, 使用writev系统调用一次写入多段数据
+//	超过单次writev最大向量数时合并为一段, 保证整批由一次系统调用写入
+//fd    	输入文件
+//bufs  	输入多段数据
+//n     	输出写入尺寸
+//err   	输出错误信息
+func writev(fd *os.File, bufs [][]byte) (n int, err error) {
+	rc, err := fd.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(bufs) > maxIovecs {
+		bufs = [][]byte{bytes.Join(bufs, nil)}
+	} else {
+		//部分写入时修改数据段, 不修改调用者切片
+		bufs = append(make([][]byte, 0, len(bufs)), bufs...)
+	}
+	iovs := make([]syscall.Iovec, 0, len(bufs))
+	for len(bufs) > 0 {
+		iovs = iovs[:0]
+		for _, b := range bufs {
+			if len(b) > 0 {
+				iov := syscall.Iovec{Base: &b[0]}
+				iov.SetLen(len(b))
+				iovs = append(iovs, iov)
+			}
+		}
+		if len(iovs) == 0 {
+			return n, nil
+		}
+
+		var wrote uintptr
+		var errno syscall.Errno
+		e := rc.Write(func(s uintptr) bool {
+			wrote, _, errno = syscall.Syscall(syscall.SYS_WRITEV, s,
+				uintptr(unsafe.Pointer(&iovs[0])), uintptr(len(iovs)))
+			return errno != syscall.EAGAIN
+		})
+		if e != nil {
+			return n, e
+		}
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return n, os.NewSyscallError("writev", errno)
+		}
+
+		//跳过已写入的数据段, 部分写入时继续写剩余数据
+		n += int(wrote)
+		for left := int(wrote); len(bufs) > 0; bufs = bufs[1:] {
+			if left < len(bufs[0]) {
+				bufs[0] = bufs[0][left:]
+				break
+			}
+			left -= len(bufs[0])
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+// +build !linux
+
+// fileWritev
+package fwrite
+
+import (
+	"os"
+)
+
+//向量写文件, 合并多段数据后一次写入
+//fd    	输入文件
+//bufs  	输入多段数据
+//n     	输出写入尺寸
+//err   	输出错误信息
+func writev(fd *os.File, bufs [][]byte) (n int, err error) {
+	size := 0
+	for _, b := range bufs {
+		size += len(b)
+	}
+	buf := make([]byte, 0, size)
+	for _, b := range bufs {
+		buf = append(buf, b...)
+	}
+	return fd.Write(buf)
+}
@@ -12,14 +12,16 @@ const (
 	zipFileSuffix = ".zip"
 )
 
-func zipLogFile(fileName string) error {
+//压缩文件, 加密文件先解密再压缩, 压缩后再加密, 校验清单由调用者更新
+//fileName	输入文件名
+//keys    	输入密钥提供者, 为空时不加密
+func zipLogFile(fileName string, keys KeyProvider) (err error) {
 	defer func() {
 		if x := recover(); x != nil {
-			errorf("zipFile [%v] Error: %v\n", fileName, x)
-			errorf("zipFile Stack => %s\n", debug.Stack())
+			err = errorf("zip panic: %v\n%s", x, debug.Stack())
 		}
 	}()
-	srcfd, err := os.Open(fileName)
+	srcfd, err := openPlain(fileName, keys)
 	if err != nil {
 		return err
 	}
@@ -31,7 +33,7 @@ func zipLogFile(fileName string) error {
 		return err
 	}
 
-	info, err := srcfd.Stat()
+	info, err := os.Stat(fileName)
 	if err != nil {
 		return err
 	}
@@ -40,7 +42,17 @@ func zipLogFile(fileName string) error {
 		return err
 	}
 	header.Method = zip.Deflate
-	zipWrite := zip.NewWriter(zipFd)
+	var zipOut io.Writer = zipFd
+	var cryptOut *cryptWriter
+	if keys != nil {
+		if cryptOut, err = newCryptWriter(zipFd, keys); err != nil {
+			srcfd.Close()
+			zipFd.Close()
+			return err
+		}
+		zipOut = cryptOut
+	}
+	zipWrite := zip.NewWriter(zipOut)
 	writer, err := zipWrite.CreateHeader(header)
 	if err != nil {
 		return err
@@ -48,6 +60,9 @@ func zipLogFile(fileName string) error {
 	_, err = io.Copy(writer, srcfd)
 	if err == nil {
 		zipErr := zipWrite.Close()
+		if zipErr == nil && cryptOut != nil {
+			zipErr = cryptOut.close()
+		}
 		srcfd.Close()
 		zipFd.Close()
 		if zipErr == nil {
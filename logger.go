@@ -2,13 +2,16 @@
 package fwrite
 
 import (
+	"bytes"
+	"fmt"
 	"io"
 	"os"
 	"sync/atomic"
 )
 
 var (
-	outVal atomic.Value
+	outVal    atomic.Value
+	loggerVal atomic.Value
 )
 
 type logger struct {
@@ -23,7 +26,8 @@ func (w logger) Write(p []byte) (n int, err error) {
 }
 
 func init() {
-	setOutput(os.Stdout)
+	setOutput(os.Stderr)
+	SetDefaultLogger(NewTextLogger(nil, LevelInfo))
 }
 
 func setOutput(output io.Writer) {
@@ -38,10 +42,171 @@ func output() io.Writer {
 	if w, ok := outVal.Load().(io.Writer); ok {
 		return w
 	} else {
-		return os.Stdout
+		return os.Stderr
 	}
 }
 
+//设置默认文本日志器的输出, 默认为Stderr, 不与应用的Stdout输出交错
 func SetOutput(output io.Writer) {
 	setOutput(output)
 }
+
+//诊断日志级别
+type Level int32
+
+const (
+	LevelTrace Level = iota //跟踪
+	LevelDebug              //调试
+	LevelInfo               //信息
+	LevelWarn               //警告
+	LevelError              //错误
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelTrace:
+		return "TRACE"
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return sprintf("LEVEL(%d)", int32(l))
+}
+
+//诊断日志字段
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+//诊断日志器接口
+type Logger interface {
+
+	//是否输出指定级别
+	//level	输入日志级别
+	Enabled(level Level) bool
+
+	//输出日志
+	//level 	输入日志级别
+	//msg   	输入日志消息
+	//fields	输入结构化字段: writer, file, op, error 等
+	Log(level Level, msg string, fields ...Field)
+}
+
+//文本日志器, 按"<LEVEL>[时间] 消息 键=值"格式输出
+type TextLogger struct {
+	out   io.Writer
+	level int32
+}
+
+//创建文本日志器
+//out  	输入输出, 为空时使用SetOutput设置的输出(默认Stderr)
+//level	输入最低输出级别
+func NewTextLogger(out io.Writer, level Level) *TextLogger {
+	return &TextLogger{out: out, level: int32(level)}
+}
+
+//设置最低输出级别, 可在运行时切换
+func (l *TextLogger) SetLevel(level Level) {
+	atomic.StoreInt32(&l.level, int32(level))
+}
+
+func (l *TextLogger) Enabled(level Level) bool {
+	return int32(level) >= atomic.LoadInt32(&l.level)
+}
+
+func (l *TextLogger) Log(level Level, msg string, fields ...Field) {
+	if !l.Enabled(level) {
+		return
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<%s>[%s] %s", level, logTime(), msg)
+	for _, f := range fields {
+		switch v := f.Value.(type) {
+		case string:
+			fmt.Fprintf(&buf, " %s=%q", f.Key, v)
+		case error:
+			fmt.Fprintf(&buf, " %s=%q", f.Key, v.Error())
+		default:
+			fmt.Fprintf(&buf, " %s=%v", f.Key, v)
+		}
+	}
+	buf.WriteString("\n\n")
+	out := l.out
+	if out == nil {
+		out = output()
+	}
+	out.Write(buf.Bytes())
+}
+
+//日志器存储包装, atomic.Value要求类型一致
+type loggerBox struct {
+	l Logger
+}
+
+//设置进程默认日志器, 未设置日志器的写入器和包级操作使用
+func SetDefaultLogger(l Logger) {
+	if l == nil {
+		l = NewTextLogger(nil, LevelInfo)
+	}
+	loggerVal.Store(loggerBox{l})
+}
+
+//获取进程默认日志器
+func DefaultLogger() Logger {
+	return loggerVal.Load().(loggerBox).l
+}
+
+//设置写入器的日志器
+//l	输入日志器, 为空时使用进程默认日志器
+func (w *FileWrite) SetLogger(l Logger) {
+	w.mu.Lock()
+	w.cfg.Logger = l
+	w.mu.Unlock()
+}
+
+//输出诊断日志
+//l    	输入日志器
+//level	输入日志级别
+//name 	输入写入器名称
+//op   	输入操作
+//file 	输入文件名
+//err  	输入错误信息
+//fields	输入附加字段
+func logEvent(l Logger, level Level, name, op, file string, err error, fields ...Field) {
+	if !l.Enabled(level) {
+		return
+	}
+	all := make([]Field, 0, 4+len(fields))
+	if name != "" {
+		all = append(all, Field{"writer", name})
+	}
+	if file != "" {
+		all = append(all, Field{"file", file})
+	}
+	all = append(all, Field{"op", op})
+	if err != nil {
+		all = append(all, Field{"error", err})
+	}
+	l.Log(level, op, append(all, fields...)...)
+}
+
+//输出写入器诊断日志
+func (w *FileWrite) log(level Level, op, file string, err error, fields ...Field) {
+	logEvent(w.cfger.GetLogger(), level, w._Name_, op, file, err, fields...)
+}
+
+//输出互斥写诊断日志
+func (mw *MutexWrite) log(level Level, op, file string, err error, fields ...Field) {
+	logEvent(mw.cfger.GetLogger(), level, mw._Name_, op, file, err, fields...)
+}
+
+//输出配置诊断日志
+func (c *FileConfig) log(level Level, op, file string, err error, fields ...Field) {
+	logEvent(c.GetLogger(), level, c.Name, op, file, err, fields...)
+}
@@ -0,0 +1,51 @@
+//go:build go1.21
+// +build go1.21
+
+// logger
+package fwrite
+
+import (
+	"context"
+	"log/slog"
+)
+
+//slog日志器适配
+type slogLogger struct {
+	l *slog.Logger
+}
+
+//创建slog日志器适配, TRACE级别映射为slog.LevelDebug-4
+//l	输入slog日志器, 为空时使用slog.Default()
+func NewSlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return &slogLogger{l: l}
+}
+
+//转换为slog级别
+func slogLevel(level Level) slog.Level {
+	switch level {
+	case LevelTrace:
+		return slog.LevelDebug - 4
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelInfo:
+		return slog.LevelInfo
+	case LevelWarn:
+		return slog.LevelWarn
+	}
+	return slog.LevelError
+}
+
+func (s *slogLogger) Enabled(level Level) bool {
+	return s.l.Enabled(context.Background(), slogLevel(level))
+}
+
+func (s *slogLogger) Log(level Level, msg string, fields ...Field) {
+	attrs := make([]slog.Attr, len(fields))
+	for i, f := range fields {
+		attrs[i] = slog.Any(f.Key, f.Value)
+	}
+	s.l.LogAttrs(context.Background(), slogLevel(level), msg, attrs...)
+}
@@ -1,9 +1,63 @@
 package fwrite
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
 func TestLogWrite(t *testing.T) {
 	output().Write([]byte("Test"))
 }
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	text := NewTextLogger(&buf, LevelInfo)
+	logEvent(text, LevelTrace, "TestLogger", "close", "a.log", nil)
+	if buf.Len() != 0 {
+		t.Fatalf("trace output %q", buf.String())
+	}
+	logEvent(text, LevelError, "TestLogger", "rotate", "a.log", errors.New("disk full"))
+	want := `rotate writer="TestLogger" file="a.log" op="rotate" error="disk full"`
+	if line := buf.String(); !strings.HasPrefix(line, "<ERROR>[") || !strings.Contains(line, want) {
+		t.Fatalf("text output %q", line)
+	}
+
+	//写入器日志器与slog适配
+	buf.Reset()
+	h := slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug - 4})
+	w := NewFileWrite("TestLogger")
+	w.SetLogger(NewSlogLogger(slog.New(h)))
+	_, err := w.Init(false, filepath.Join(t.TempDir(), "TestLogger"), "log", "log", "log",
+		true, false, false, false, 1, 0, false, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString("record-1\n")
+	w.WriteString("record-2\n")
+	w.Close()
+
+	var entry struct {
+		Level  string `json:"level"`
+		Msg    string `json:"msg"`
+		Writer string `json:"writer"`
+		File   string `json:"file"`
+		Op     string `json:"op"`
+	}
+	found := false
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		if err = json.Unmarshal(line, &entry); err != nil {
+			t.Fatalf("slog line %q: %v", line, err)
+		}
+		if entry.Op == "close" && entry.Writer == "TestLogger.MWrite" && entry.File != "" {
+			found = entry.Level == "DEBUG-4"
+		}
+	}
+	if !found {
+		t.Fatalf("slog output %s", buf.String())
+	}
+}
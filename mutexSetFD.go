@@ -7,7 +7,7 @@ import (
 	flock "github.com/yireyun/go-flock"
 )
 
-//互斥设置文件（Go程安全）
+//互斥设置文件（Go程安全）, 旧文件重命名失败时仍打开新文件, 之后返回重命名错误RenameError
 //fileSync  	输入新创建文件是否同步文件
 //fileLock  	输入新创建文件是否加锁文件
 //rename    	输入是否重命名当前文件
@@ -29,41 +29,40 @@ func (mw *MutexWrite) setFd(fileSync, fileLock, rename bool,
 	mw.mutex.Lock()
 	defer mw.mutex.Unlock()
 
-	isLocked := false
+	files := mw.cfger.GetRegistry()
+	var holdFile *os.File
+	var sealErr error
+	prevName := ""
 
 	if mw.file != nil && mw.file != os.Stdout {
 
 		curName := mw.file.Name()
+		prevName = curName
 
-		if !mw.closed && len(fileEof) > 0 {
-			mw.file.Write(fileEof)
-		}
+		sealStats := mw.writeFileEnd(fileName, fileEof)
 		//关闭文件
 		if !mw.closed {
 			err = mw.file.Close()
+			mw.index.close()
 			if !rename {
-				//如果不修改名文件名, 则不解出锁定
-				fLocks.Unlock(mw.file)
-				isLocked = false
+				//如果不修改名文件名, 则解除登记
+				files.Release(mw.file)
 			} else {
-				//检查锁是否存在,不存在则 NEWFILE 时需要枷锁
-				isLocked = fLocks.Exists(curName)
+				//重命名期间保持登记, NEWFILE 时替换为新文件
+				holdFile = mw.file
 			}
 
 			mw.closed = true
 			if err != nil {
-				printf("<ERROR>[%s] %s close \"%s\" error:%v\n\n",
-					logTime(), mw._Name_, curName, err)
+				mw.log(LevelError, "close", curName, err)
 			}
 		}
 
 		//解除锁定
 		if mw.flock != nil {
-			err = mw.flock.Unlock() // ▲ 解锁当前文件锁
-			mw.flock = nil
+			err = mw.unlockFile(curName + LockSuffix) // ▲ 解锁当前文件锁
 			if err != nil {
-				printf("<ERROR>[%s] %s unlock \"%s\" error:%v\n\n",
-					logTime(), mw._Name_, curName, err)
+				mw.fail("unlock", curName, newLockError(curName+LockSuffix, err))
 			}
 		}
 
@@ -71,16 +70,19 @@ func (mw *MutexWrite) setFd(fileSync, fileLock, rename bool,
 		if rename {
 
 			if curName == "" {
-				printf("<ERROR>[%s] %s rename old file error:%v\n\n",
-					logTime(), mw._Name_, ErrNameEmpty)
+				sealErr = mw.fail("rename", curName, &RenameError{From: curName, Err: ErrNameEmpty})
 				goto NEWFILE
 			}
 			if e := os.Rename(curName, fileRename); e != nil {
-				printf("<ERROR>[%s] %s os.Rename \"%s\" -> \"%s\" error:%v\n\n",
-					logTime(), mw._Name_, curName, fileRename, e)
+				sealErr = mw.fail("rename", curName, &RenameError{From: curName, To: fileRename, Err: e})
 				goto NEWFILE
-			} else if mw.cfger.IsFileZip() {
-				go zipLogFile(fileRename)
+			}
+			sealRename(curName, fileRename)
+			mw.sealManifest(fileRename)
+			mw.sealEvent(curName, fileRename, sealStats)
+			prevName = fileRename
+			if mw.cfger.IsFileZip() {
+				go zipReport(mw.cfger, mw._Name_, fileRename)
 			}
 		}
 	}
@@ -101,21 +103,37 @@ NEWFILE:
 		} else {
 			fileSize = fs.Size()
 		}
+		if fileSize, err = mw.openCrypt(fd, fileName, fileSize); err != nil {
+			fd.Close()
+			return err
+		}
+		fileSize = mw.repairTail(fd, fileName, fileSize)
 		mw.file, mw.closed, mw.stdout = fd, false, false
+		headLines := int64(0)
+		mw.resetStats(fileSize)
+		mw.openIndex(fileName, fileSize)
+		mw.resumeChain(fileName, fileSize)
+		if fileSize == 0 { //新文件写入文件头
+			fileSize, headLines = mw.writeHeader(fileName, prevName)
+		}
 		mw.cfger.setCurFileName(fileName, fileSize)
-		if !isLocked {
-			fLocks.DoLock(mw.file)
+		mw.cfger.setCurHeader(headLines)
+		mw.openEvent(fileName, fileSize)
+		if holdFile != nil {
+			files.Release(holdFile)
 		}
+		files.Acquire(mw.file)
 
 		//锁定文件
 		if fileLock {
 			mw.flock = flock.NewFlock(fileName + LockSuffix)
 			err = mw.flock.NBLock() // ▲ 解锁当前文件锁
 			if err != nil {
-				printf("<ERROR>[%s] %s lock \"%s\" error:%v\n\n",
-					logTime(), mw._Name_, fileName, err)
+				mw.fail("lock", fileName, newLockError(fileName+LockSuffix, err))
+			} else {
+				mw.ownLock(fileName + LockSuffix)
 			}
 		}
-		return nil
+		return sealErr
 	}
 }
@@ -7,7 +7,7 @@ import (
 	flock "github.com/yireyun/go-flock"
 )
 
-//互斥切换文件（Go程安全）
+//互斥切换文件（Go程安全）, 旧文件重命名失败时仍打开新文件, 之后返回重命名错误RenameError
 func (mw *MutexWrite) SwitchFD() (err error) {
 	if mw == nil {
 		return ErrFileNil
@@ -21,44 +21,42 @@ func (mw *MutexWrite) SwitchFD() (err error) {
 	mw.mutex.Lock()
 	defer mw.mutex.Unlock()
 
-	isLocked := false
+	files := mw.cfger.GetRegistry()
+	var holdFile *os.File
+	var sealErr error
+	prevName := ""
 
 	if mw.file != nil && mw.file != os.Stdout {
 
 		curName := mw.file.Name()
+		prevName = curName
 
-		if !mw.closed && len(fileEof) > 0 {
-			mw.file.Write(fileEof)
-		}
+		sealStats := mw.writeFileEnd(mw.cfger.GetActiveName(), fileEof)
 		//关闭文件
 		if !mw.closed {
 			err = mw.file.Close()
+			mw.index.close()
 			if !isRename {
-				//如果不修改名文件名, 则不解出锁定
-				fLocks.Unlock(mw.file)
-				isLocked = false
+				//如果不修改名文件名, 则解除登记
+				files.Release(mw.file)
 			} else {
-				//检查锁是否存在,不存在则 NEWFILE 时需要枷锁
-				isLocked = fLocks.Exists(curName)
+				//重命名期间保持登记, NEWFILE 时替换为新文件
+				holdFile = mw.file
 			}
 
 			mw.closed = true
 			if err != nil {
-				printf("<ERROR>[%s] %s close \"%s\" error:%v\n\n",
-					logTime(), mw._Name_, curName, err)
+				mw.log(LevelError, "close", curName, err)
 			} else {
-				printf("<TRACE>[%s] %s close \"%s\" success\n\n",
-					logTime(), mw._Name_, curName)
+				mw.log(LevelTrace, "close", curName, nil)
 			}
 		}
 
 		//解除锁定
 		if mw.flock != nil {
-			err = mw.flock.Unlock() // ▲ 解锁当前文件锁
-			mw.flock = nil
+			err = mw.unlockFile(curName + LockSuffix) // ▲ 解锁当前文件锁
 			if err != nil {
-				printf("<ERROR>[%s] %s unlock \"%s\" error:%v\n\n",
-					logTime(), mw._Name_, curName, err)
+				mw.fail("unlock", curName, newLockError(curName+LockSuffix, err))
 			}
 		}
 
@@ -66,30 +64,32 @@ func (mw *MutexWrite) SwitchFD() (err error) {
 		if isRename {
 
 			if curName == "" {
-				printf("<ERROR>[%s] %s rename old file error:%v\n\n",
-					logTime(), mw._Name_, ErrNameEmpty)
+				sealErr = mw.fail("rename", curName, &RenameError{From: curName, Err: ErrNameEmpty})
 				goto NEWFILE
 			}
 
 			fileRename, renameErr := mw.cfger.GetFileRename(curName)
 			if renameErr != nil {
-				printf("<ERROR>[%s] %s get \"%s\" rename error:%v\n\n",
-					logTime(), mw._Name_, curName, renameErr)
+				sealErr = mw.fail("get rename", curName, &RenameError{From: curName, Err: renameErr})
 				goto NEWFILE
 			}
 
 			if fileRename == "" || fileRename == curName {
-				printf("<ERROR>[%s] %s rename \"%s\" -> \"%s\" error:%v\n\n",
-					logTime(), mw._Name_, curName, fileRename, ErrNameSame)
+				sealErr = mw.fail("rename", curName,
+					&RenameError{From: curName, To: fileRename, Err: ErrNameSame})
 				goto NEWFILE
 			}
 
 			if e := os.Rename(curName, fileRename); e != nil {
-				printf("<ERROR>[%s] %s os.Rename \"%s\" -> \"%s\" error:%v\n\n",
-					logTime(), mw._Name_, curName, fileRename, e)
+				sealErr = mw.fail("rename", curName, &RenameError{From: curName, To: fileRename, Err: e})
 				goto NEWFILE
-			} else if mw.cfger.IsFileZip() {
-				go zipLogFile(fileRename)
+			}
+			sealRename(curName, fileRename)
+			mw.sealManifest(fileRename)
+			mw.sealEvent(curName, fileRename, sealStats)
+			prevName = fileRename
+			if mw.cfger.IsFileZip() {
+				go zipReport(mw.cfger, mw._Name_, fileRename)
 			}
 		}
 	}
@@ -110,58 +110,75 @@ NEWFILE:
 		fs, fe := fd.Stat()
 		if fe != nil {
 			fd.Close()
-			continue
+			return fe
 		}
-		if mw.cfger.IsZeroSize() && fs.Size() > 0 {
+		//加密时无法续写的已有文件与零尺寸模式一样重命名
+		fileSize, cryptErr := mw.openCrypt(fd, fileName, fs.Size())
+		if cryptErr != nil && fs.Size() == 0 {
 			fd.Close()
-			if isRename {
-				fileRename, renameErr := mw.cfger.GetFileRename(fileName)
-				if renameErr != nil {
-					printf("<ERROR>[%s] %s get \"%s\" rename error:%v\n\n",
-						logTime(), mw._Name_, fileName, renameErr)
-					continue
+			return cryptErr
+		}
+		if (mw.cfger.IsZeroSize() || cryptErr != nil) && fs.Size() > 0 {
+			if cryptErr == nil && mw.crypt != nil {
+				fd.Write(mw.crypt.final())
+			}
+			fd.Close()
+			//无法重命名时新文件名不变, 返回错误避免重复打开同一文件
+			if !isRename {
+				if cryptErr == nil {
+					cryptErr = ErrNameSame
 				}
+				return mw.fail("rename", fileName,
+					&RenameError{From: fileName, To: fileName, Err: cryptErr})
+			}
+			fileRename, renameErr := mw.cfger.GetFileRename(fileName)
+			if renameErr != nil {
+				return mw.fail("get rename", fileName, &RenameError{From: fileName, Err: renameErr})
+			}
 
-				if fileRename == "" || fileRename == fileName {
-					printf("<ERROR>[%s] %s rename \"%s\" -> \"%s\" error:%v\n\n",
-						logTime(), mw._Name_, fileName, fileRename, ErrNameSame)
-					continue
-				}
+			if fileRename == "" || fileRename == fileName {
+				return mw.fail("rename", fileName,
+					&RenameError{From: fileName, To: fileRename, Err: ErrNameSame})
+			}
 
-				if e := os.Rename(fileName, fileRename); e != nil {
-					printf("<ERROR>[%s] %s os.Rename \"%s\" -> \"%s\" error:%v\n\n",
-						logTime(), mw._Name_, fileName, fileRename, e)
-					continue
-				} else if mw.cfger.IsFileZip() {
-					go zipLogFile(fileRename)
-				}
-			} else {
-				var newNameErr error
-				fileName, newNameErr = mw.cfger.GetNewFileName()
-				if newNameErr != nil {
-					printf("<ERROR>[%s] %s get \"%s\" rename error:%v\n\n",
-						logTime(), mw._Name_, fileName, newNameErr)
-					continue
-				}
+			if e := os.Rename(fileName, fileRename); e != nil {
+				return mw.fail("rename", fileName,
+					&RenameError{From: fileName, To: fileRename, Err: e})
+			}
+			sealRename(fileName, fileRename)
+			mw.sealEvent(fileName, fileRename, nil)
+			if mw.cfger.IsManifest() || mw.cfger.IsFileZip() {
+				go sealReport(mw.cfger, mw._Name_, fileRename)
 			}
 			continue
 		}
 		mw.file, mw.closed, mw.stdout = fd, false, false
-		mw.cfger.setCurFileName(fileName, fs.Size())
-		if !isLocked {
-			fLocks.DoLock(mw.file)
+		fileSize, headLines := mw.repairTail(fd, fileName, fileSize), int64(0)
+		mw.resetStats(fileSize)
+		mw.openIndex(fileName, fileSize)
+		mw.resumeChain(fileName, fileSize)
+		if fileSize == 0 { //新文件写入文件头
+			fileSize, headLines = mw.writeHeader(fileName, prevName)
 		}
+		mw.cfger.setCurFileName(fileName, fileSize)
+		mw.cfger.setCurHeader(headLines)
+		mw.openEvent(fileName, fileSize)
+		if holdFile != nil {
+			files.Release(holdFile)
+		}
+		files.Acquire(mw.file)
 
 		//锁定文件
 		if isFileLock {
 			mw.flock = flock.NewFlock(fileName + LockSuffix)
 			err = mw.flock.NBLock()
 			if err != nil {
-				printf("<ERROR>[%s] %s lock \"%s\" error:%v\n\n",
-					logTime(), mw._Name_, fileName, err)
+				mw.fail("lock", fileName, newLockError(fileName+LockSuffix, err))
+			} else {
+				mw.ownLock(fileName + LockSuffix)
 			}
 		}
-		return nil
+		return sealErr
 	}
 	return ErrFileSwitch
 }
@@ -2,6 +2,7 @@
 package fwrite
 
 import (
+	"bytes"
 	"os"
 	"sync"
 
@@ -16,6 +17,15 @@ type MutexConfiger interface {
 	//是否启用文件锁
 	IsFileLock() bool
 
+	//获取打开文件登记表
+	GetRegistry() *FileRegistry
+
+	//获取诊断日志器
+	GetLogger() Logger
+
+	//报告错误
+	reportError(err error)
+
 	//新文件零尺寸
 	IsZeroSize() bool
 
@@ -33,6 +43,9 @@ type MutexConfiger interface {
 	//设置文件名
 	setCurFileName(fileName string, fileSize int64)
 
+	//设置文件头行数
+	setCurHeader(headLines int64)
+
 	//获取重命名文件名
 	//fileName  	是输入文件名
 	//fileRename	是输出重命名文件名
@@ -41,6 +54,50 @@ type MutexConfiger interface {
 
 	//获取文件结束填充
 	GetFileEof() []byte
+
+	//获取文件开始填充
+	//info	是输入文件头信息
+	GetFileHeader(info *HeaderInfo) []byte
+
+	//文件头是否计入行数
+	IsHeaderLines() bool
+
+	//是否统计文件信息
+	IsFileStats() bool
+
+	//获取索引间隔行数
+	GetIndexEvery() int64
+
+	//是否二进制帧模式
+	IsFrameMode() bool
+
+	//获取尾部修复方式和封存标记
+	GetTailRepair() (int, []byte)
+
+	//是否每条记录都以换行结尾
+	IsLineEnded() bool
+
+	//获取哈希链间隔记录数和HMAC密钥
+	GetChain() (int64, []byte)
+
+	//是否封存时写入校验清单
+	IsManifest() bool
+
+	//获取加密密钥提供者
+	GetKeyProvider() KeyProvider
+
+	//获取文件结束填充
+	//stats	是输入文件统计信息
+	GetFileTrailer(stats *FileStats) []byte
+
+	//是否生成文件结束记录
+	IsFileTrailer() bool
+
+	//触发生命周期事件
+	fireEvent(ev Event)
+
+	//获取活动文件名, 旋转后后续记录写入该文件
+	GetActiveName() string
 }
 
 //互斥写文件
@@ -50,8 +107,15 @@ type MutexWrite struct {
 	file   *os.File      //当前输出文件
 	cfger  MutexConfiger //配置信息接口
 	flock  flock.Flocker //当前输出文件文件锁
+	owner  *lockOwner    //当前文件锁持有者, 未锁定为nil
 	stdout bool          //当前输出文件是控制台
 	closed bool          //当前输出文件是否被关闭
+	stats  fileStats     //当前输出文件统计
+	index  fileIndex     //当前输出文件索引
+	repair *RepairReport //最后一次尾部修复报告
+	chain  fileChain     //当前文件哈希链
+	crypt  *cryptStream  //当前文件加密块流, 不加密为nil
+	sealed *Event        //最近一次封存事件, 旋转完成时取出
 }
 
 func NewMutexWrite(cfger MutexConfiger) *MutexWrite {
@@ -113,7 +177,11 @@ func (mw *MutexWrite) Write(b []byte) (int, error) {
 		return 0, ErrFileClosed
 	}
 
-	return mw.file.Write(b)
+	n, err := mw.writeFile(b)
+	mw.stats.add(b[:n], 1)
+	mw.chain.add(b[:n])
+	mw.index.add(b[:n])
+	return n, err
 }
 
 //互斥写字符串（Go程安全）
@@ -129,7 +197,63 @@ func (mw *MutexWrite) WriteString(s string) (int, error) {
 		return 0, ErrFileClosed
 	}
 
-	return mw.file.WriteString(s)
+	if mw.crypt != nil {
+		n, err := mw.writeFile([]byte(s))
+		mw.stats.addString(s[:n], 1)
+		mw.index.add([]byte(s[:n]))
+		mw.chain.add([]byte(s[:n]))
+		return n, err
+	}
+
+	n, err := mw.file.WriteString(s)
+	mw.stats.addString(s[:n], 1)
+	if mw.index.fd != nil || mw.chain.on || mw.stats.on {
+		b := []byte(s[:n])
+		mw.index.add(b)
+		mw.chain.add(b)
+		mw.stats.addFile(b, b)
+	}
+	return n, err
+}
+
+//互斥批量写数据（Go程安全）, 一次系统调用写入全部数据
+func (mw *MutexWrite) Writev(bufs [][]byte) (int, error) {
+	if mw == nil {
+		return 0, ErrFileNil
+	}
+
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
+	if mw.closed {
+		return 0, ErrFileClosed
+	}
+
+	var n int
+	var err error
+	if mw.crypt != nil { //加密时整批作为一个密文块
+		n, err = mw.writeFile(bytes.Join(bufs, nil))
+	} else {
+		n, err = writev(mw.file, bufs)
+	}
+	if !mw.stats.on && mw.index.fd == nil && !mw.chain.on {
+		return n, err
+	}
+	//只统计实际写入的数据, 部分写入时不计未写入部分
+	for i, left := 0, n; i < len(bufs) && left > 0; i++ {
+		b := bufs[i]
+		if len(b) > left {
+			b = b[:left]
+		}
+		left -= len(b)
+		mw.stats.add(b, 1)
+		mw.index.add(b)
+		mw.chain.add(b)
+		if mw.crypt == nil {
+			mw.stats.addFile(b, b)
+		}
+	}
+	return n, err
 }
 
 //写入缓存数据
@@ -174,31 +298,27 @@ func (mw *MutexWrite) Close() (err error) {
 
 		curName := mw.file.Name()
 
-		if !mw.closed && len(fileEof) > 0 {
-			mw.file.Write(fileEof)
-		}
+		sealStats := mw.writeFileEnd("", fileEof)
 		//关闭文件
 		if !mw.closed {
 			err = mw.file.Close()
-			fLocks.Unlock(mw.file)
+			mw.index.close()
+			mw.cfger.GetRegistry().Release(mw.file)
 			mw.closed = true
 			if err != nil {
-				printf("<ERROR>[%s] %s close \"%s\" error:%v\n\n",
-					logTime(), mw._Name_, curName, err)
+				mw.log(LevelError, "close", curName, err)
 			}
 		}
 
 		//解除锁定
 		if mw.flock != nil {
-			err = mw.flock.Unlock() // ▲ 解锁当前文件锁
-			mw.flock = nil
+			err = mw.unlockFile(curName + LockSuffix) // ▲ 解锁当前文件锁
 			if err != nil {
-				printf("<ERROR>[%s] %s unlock \"%s\" error:%v\n\n",
-					logTime(), mw._Name_, curName, err)
+				mw.fail("unlock", curName, newLockError(curName+LockSuffix, err))
 			}
 		}
 
-		stat, exist, locked, err := FileInfo(mw.file.Name())
+		stat, exist, locked, err := mw.cfger.GetRegistry().FileInfo(mw.file.Name())
 		if err != nil {
 			return err
 		}
@@ -206,30 +326,27 @@ func (mw *MutexWrite) Close() (err error) {
 		if rename && exist && !locked && stat.Size() > 0 {
 
 			if curName == "" {
-				printf("<ERROR>[%s] %s rename old file error:%v\n\n",
-					logTime(), mw._Name_, ErrNameEmpty)
-				return ErrNameEmpty
+				return mw.fail("rename", curName, &RenameError{From: curName, Err: ErrNameEmpty})
 			}
 
 			fileRename, renameErr := mw.cfger.GetFileRename(curName)
 			if renameErr != nil {
-				printf("<ERROR>[%s] %s get \"%s\" rename error:%v\n\n",
-					logTime(), mw._Name_, curName, renameErr)
-				return renameErr
+				return mw.fail("get rename", curName, &RenameError{From: curName, Err: renameErr})
 			}
 
 			if fileRename == "" || fileRename == curName {
-				printf("<ERROR>[%s] %s rename \"%s\" -> \"%s\" error: %v\n\n",
-					logTime(), mw._Name_, curName, fileRename, ErrNameSame)
-				return ErrNameSame
+				return mw.fail("rename", curName,
+					&RenameError{From: curName, To: fileRename, Err: ErrNameSame})
 			}
 
 			if e := os.Rename(curName, fileRename); e != nil {
-				printf("<ERROR>[%s] %s os.Rename \"%s\" -> \"%s\" error: %v\n\n",
-					logTime(), mw._Name_, curName, fileRename, e)
-				return e
-			} else if mw.cfger.IsFileZip() {
-				go zipLogFile(fileRename)
+				return mw.fail("rename", curName, &RenameError{From: curName, To: fileRename, Err: e})
+			}
+			sealRename(curName, fileRename)
+			mw.sealManifest(fileRename)
+			mw.sealEvent(curName, fileRename, sealStats)
+			if mw.cfger.IsFileZip() {
+				go zipReport(mw.cfger, mw._Name_, fileRename)
 			}
 		}
 	}